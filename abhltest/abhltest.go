@@ -0,0 +1,176 @@
+// Package abhltest helps Go tests assemble and run ABhL programs.
+//
+//	func TestEcho(t *testing.T) {
+//		img := abhltest.AssembleFiles(t, "../tests/echo/echo.owl")
+//		r := abhltest.Run(t, img, "hi\x00", nil)
+//		r.AssertExit(t, 0)
+//		r.AssertStdout(t, "hi\x00")
+//	}
+package abhltest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+// MaxSteps limits how many steps Run executes after IPL.
+var MaxSteps = 10000000
+
+// Logf logs every step of the Vm in Run.  By default it is quiet.
+var Logf = func(format string, args ...any) {}
+
+// Image is an assembled program, ready for IPL.
+type Image struct {
+	Mod *OWL.Mod
+	IPL []byte
+}
+
+// Addr returns the value of a label in the image, failing the test if there is no such label.
+func (img *Image) Addr(t testing.TB, label string) uint {
+	t.Helper()
+	addr, ok := img.Mod.LabelAddr(label)
+	if !ok {
+		t.Fatalf("no label %q in image", label)
+	}
+	return addr
+}
+
+// Assemble assembles the source texts, as if they were concatenated files,
+// failing the test if the assembler fails.
+func Assemble(t testing.TB, sources ...string) *Image {
+	t.Helper()
+	var lines, wheres []string
+	for i, src := range sources {
+		for j, line := range strings.Split(src, "\n") {
+			lines = append(lines, line)
+			wheres = append(wheres, fmt.Sprintf("source%d:%d", i+1, j+1))
+		}
+	}
+	return assemble(t, func() *OWL.Mod {
+		return OWL.AssembleLines(lines, wheres, nil)
+	})
+}
+
+// AssembleFiles assembles the source files in order,
+// failing the test if the assembler fails.
+func AssembleFiles(t testing.TB, filenames ...string) *Image {
+	t.Helper()
+	return assemble(t, func() *OWL.Mod {
+		return OWL.AssembleFiles(filenames, nil)
+	})
+}
+
+func assemble(t testing.TB, fn func() *OWL.Mod) (img *Image) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("assembler failed: %v", r)
+		}
+	}()
+	mod := fn()
+	return &Image{Mod: mod, IPL: OWL.CreateIPL(mod)}
+}
+
+// Result is the state of a program after Run.
+type Result struct {
+	Vm     *OWL.Vm
+	Stdout []byte // written to port F
+	Exited bool   // program wrote to port G
+	Status byte   // what it wrote to port G
+	Steps  uint64 // steps executed after IPL
+}
+
+// Run loads the image into a fresh Vm and runs it until it exits,
+// stops on an undefined instruction, or reaches MaxSteps.
+// Port F reads stdin (and then 0's) and port G reads the args.
+func Run(t testing.TB, img *Image, stdin string, args []string) *Result {
+	t.Helper()
+	vm := &OWL.Vm{Logf: Logf}
+	term := &OWL.BufferPort{Input: []byte(stdin)}
+	exit := OWL.NewArgsExit(vm, args)
+	vm.F, vm.G = term, exit
+
+	r := &Result{Vm: vm}
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				t.Fatalf("emulator failed at pc=%06x after %d steps: %v", vm.PC(), vm.StepNum(), e)
+			}
+		}()
+		vm.IPL(img.IPL)
+		vm.Steps(MaxSteps)
+	}()
+	r.Stdout = term.Output.Bytes()
+	r.Exited = exit.Exited
+	r.Status = exit.Status
+	r.Steps = vm.StepNum()
+	return r
+}
+
+// AssertExit checks the program exited with the status.
+func (r *Result) AssertExit(t testing.TB, status byte) {
+	t.Helper()
+	if !r.Exited {
+		t.Errorf("program did not exit; stopped at pc=%06x after %d steps", r.Vm.PC(), r.Steps)
+	} else if r.Status != status {
+		t.Errorf("program exited with status %d, want %d", r.Status, status)
+	}
+}
+
+// AssertStdout checks everything written to port F.
+func (r *Result) AssertStdout(t testing.TB, want string) {
+	t.Helper()
+	if got := string(r.Stdout); got != want {
+		t.Errorf("stdout is %q, want %q", got, want)
+	}
+}
+
+// AssertReg checks one of the primary registers A, B, H, or L, or the 24-bit W.
+func (r *Result) AssertReg(t testing.TB, reg string, want uint) {
+	t.Helper()
+	var got uint
+	switch strings.ToUpper(reg) {
+	case "A":
+		got = uint(r.Vm.A())
+	case "B":
+		got = uint(r.Vm.B())
+	case "H":
+		got = uint(r.Vm.H())
+	case "L":
+		got = uint(r.Vm.L())
+	case "W":
+		got = r.Vm.W()
+	default:
+		t.Fatalf("AssertReg: unknown register %q", reg)
+	}
+	if got != want {
+		t.Errorf("register %s is $%x, want $%x", reg, got, want)
+	}
+}
+
+// AssertMem checks the bytes in RAM starting at addr.
+func (r *Result) AssertMem(t testing.TB, addr uint, want ...byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	for i := range want {
+		got[i] = r.Vm.Peek(addr + uint(i))
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("memory at $%06x is % 02x, want % 02x", addr, got, want)
+	}
+}
+
+// AssertQuick checks quick register Q(n).
+func (r *Result) AssertQuick(t testing.TB, n uint, want byte) {
+	t.Helper()
+	if n > 15 {
+		t.Fatalf("AssertQuick: no quick register Q%d", n)
+	}
+	if got := r.Vm.Peek(n); got != want {
+		t.Errorf("Q%d is $%02x, want $%02x", n, got, want)
+	}
+}
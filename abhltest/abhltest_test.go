@@ -0,0 +1,33 @@
+package abhltest
+
+import "testing"
+
+func TestEchoFile(t *testing.T) {
+	img := AssembleFiles(t, "../tests/echo/echo.owl")
+	r := Run(t, img, "hi\x00", nil)
+	r.AssertExit(t, 0)
+	r.AssertStdout(t, "hi\x00")
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	img := Assemble(t, `
+	org $100
+start	seta 7
+	sta 3
+	setb 0
+	seth h(data)
+	setl l(data)
+	mv a,m
+	incw
+	inca
+	mv a,g
+data	fcb 0
+	fcb 0
+`)
+	r := Run(t, img, "", nil)
+	r.AssertExit(t, 8)
+	r.AssertReg(t, "A", 8)
+	r.AssertReg(t, "W", img.Addr(t, "data")+1)
+	r.AssertQuick(t, 3, 7)
+	r.AssertMem(t, img.Addr(t, "data"), 7, 0)
+}
@@ -31,6 +31,27 @@ type Mod struct {
 	currentRow  uint
 	currentBank uint
 	rowsBank    uint
+
+	// for relocatable objects (owl-asm -c), see object.go
+	reloc     bool            // assembling an object
+	lastReloc *relocTerm      // the part of the last Evaluate that the linker fills in
+	imports   map[string]bool // labels used but not defined
+	object    *objectBuilder
+
+	// for finding overlapping output, see overlap.go
+	owners  *ownership
+	genRow  *Row // the row generating now
+	overlay *Row // the OVERLAY row, until ENDOVERLAY
+
+	diags *Diagnostics // if nil, errors panic
+
+	// maxPasses limits how many times PassTwo assigns addresses.
+	// Labels that only depend on labels before them are all
+	// final after one pass, and a chain of N forward references
+	// needs N+1 passes, so if 0, it is one more than the rows.
+	maxPasses int
+
+	undefinedSeen string // the last Evaluate used this label, which has no value yet
 }
 
 type Macro struct {
@@ -51,6 +72,7 @@ type Row struct {
 	addr   uint
 	final  bool // is addr final?
 	where  string
+	line   string // the source line, for the column of a Diagnostic
 }
 
 type Instr struct {
@@ -62,10 +84,31 @@ type Expr struct { // TODO
 }
 
 type Label struct {
-	addr uint
+	addr  uint
+	reloc bool      // in an object, the linker fills in rel
+	rel   relocTerm // with reloc, what addr is relative to
+	unset bool      // no value yet, while PassTwo assigns addresses
+}
+
+// LabelAddr returns the value of a label, after assembly.
+func (mod *Mod) LabelAddr(name string) (uint, bool) {
+	lab, ok := mod.labels[name]
+	if !ok {
+		return 0, false
+	}
+	return lab.addr, true
 }
 
 func (mod *Mod) Gen(row *Row, addr uint, value byte) {
+	if row != nil {
+		mod.genRow = row
+	}
+	if mod.owners != nil && mod.genRow != nil {
+		previous := mod.owners.claim(addr, whereOf(mod.genRow))
+		if previous != "" && mod.overlay == nil {
+			mod.errorf(mod.genRow, "", "%s", overlapMessage(addr, previous))
+		}
+	}
 	mod.generated = append(mod.generated, AddrData{addr, value})
 	mod.ShowGen(row, addr, value)
 }
@@ -90,7 +133,7 @@ var Instructions = map[string]*Instr{
 	"assert": {0, func(mod *Mod, row *Row) {
 		cond := mod.EvalArg(row, 0)
 		if cond == 0 {
-			log.Panicf("Assert fails in row %#v", row)
+			mod.errorf(row, row.args[0], "assertion %q fails", row.args[0])
 		}
 		// nothing generated
 		mod.ShowGenPseudo(row, row.addr)
@@ -143,95 +186,68 @@ var Instructions = map[string]*Instr{
 		mod.ShowGenPseudo(row, row.addr)
 	}},
 	"fcw": {2, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
+		value := mod.evalArgAt(row, 0, row.addr, 2)
 		mod.Gen(row, row.addr, byte(value>>8))
 		mod.Gen(nil, row.addr+1, byte(value))
 	}},
-	"fcb": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, byte(value))
-	}},
-	"mv": {1, func(mod *Mod, row *Row) {
-		from := mod.GetArgReg(row, 0)
-		to := mod.GetArgReg(row, 1)
-		mod.Gen(row, row.addr, 0x40+byte((from<<3)+to))
-	}},
-	"lda": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0x80+byte(15&value))
-	}},
-	"ldb": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0x90+byte(15&value))
-	}},
-	"ldh": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xA0+byte(15&value))
-	}},
-	"ldl": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xB0+byte(15&value))
-	}},
-	"sta": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xC0+byte(15&value))
-	}},
-	"stb": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xD0+byte(15&value))
-	}},
-	"sth": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xE0+byte(15&value))
-	}},
-	"stl": {1, func(mod *Mod, row *Row) {
-		value := mod.EvalArg(row, 0)
-		mod.Gen(row, row.addr, 0xF0+byte(15&value))
-	}},
-	"seta": {2, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x04)
-		value := mod.EvalArg(row, 0)
-		mod.Gen(nil, row.addr+1, byte(value))
-	}},
-	"setb": {2, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x05)
-		value := mod.EvalArg(row, 0)
-		mod.Gen(nil, row.addr+1, byte(value))
-	}},
-	"seth": {2, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x06)
-		value := mod.EvalArg(row, 0)
-		mod.Gen(nil, row.addr+1, byte(value))
-	}},
-	"setl": {2, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x07)
-		value := mod.EvalArg(row, 0)
-		mod.Gen(nil, row.addr+1, byte(value))
-	}},
-	"inca": {1, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x08)
-	}},
-	"deca": {1, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x09)
+	"generable": {0, func(mod *Mod, row *Row) {
+		// nothing generated, but see Bootstrap
+		mod.ShowGenPseudo(row, row.addr)
 	}},
-	"incw": {1, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x0A)
+	"overlay": {0, func(mod *Mod, row *Row) {
+		if mod.overlay != nil {
+			mod.errorf(row, "", "OVERLAY inside OVERLAY (from %s)", mod.overlay.where)
+		}
+		mod.overlay = row
+		mod.ShowGenPseudo(row, row.addr)
 	}},
-	"decw": {1, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x0B)
+	"endoverlay": {0, func(mod *Mod, row *Row) {
+		if mod.overlay == nil {
+			mod.errorf(row, "", "ENDOVERLAY without OVERLAY")
+		}
+		mod.overlay = nil
+		mod.ShowGenPseudo(row, row.addr)
 	}},
-	"bnz": {1, func(mod *Mod, row *Row) {
-		mod.Gen(row, row.addr, 0x0C)
+	"fcb": {1, func(mod *Mod, row *Row) {
+		value := mod.evalArgAt(row, 0, row.addr, 1)
+		mod.Gen(row, row.addr, byte(value))
 	}},
 }
 
+// The machine instructions come from the ISA.
+func init() {
+	for mnemonic, sp := range ops {
+		Instructions[mnemonic] = &Instr{uint(sp.op.Size), generateOp(mnemonic, sp.op)}
+	}
+}
+
+func generateOp(mnemonic string, op *Op) func(*Mod, *Row) {
+	return func(mod *Mod, row *Row) {
+		switch op.Args {
+		case "reg,reg":
+			from := mod.GetArgReg(row, 0)
+			to := mod.GetArgReg(row, 1)
+			mod.Gen(row, row.addr, Encode(mnemonic, from, to))
+		case "quick":
+			value := mod.EvalArg(row, 0)
+			mod.Gen(row, row.addr, Encode(mnemonic, 15&value))
+		case "byte":
+			mod.Gen(row, row.addr, Encode(mnemonic))
+			value := mod.evalArgAt(row, 0, row.addr+1, 1)
+			mod.Gen(nil, row.addr+1, byte(value))
+		default:
+			mod.Gen(row, row.addr, Encode(mnemonic))
+		}
+	}
+}
+
 func (mod *Mod) GetArgReg(row *Row, i int) uint {
 	if len(row.args) < i+1 {
-		log.Panicf("not enough args: %d: %#v", i, row)
+		mod.errorf(row, "", "missing argument %d", i+1)
 	}
 	argStr := strings.TrimSpace(row.args[i])
 	if argStr == "" {
-		log.Panicf("empty arg %d: %#v", i, row)
+		mod.errorf(row, "", "empty argument %d", i+1)
 	}
 	switch strings.ToLower(argStr) {
 	case "a":
@@ -251,14 +267,14 @@ func (mod *Mod) GetArgReg(row *Row, i int) uint {
 	case "g":
 		return 7
 	default:
-		log.Panicf("Unknown register %q in arg %d in row %q", argStr, i, row)
+		mod.errorf(row, argStr, "unknown register %q in argument %d", argStr, i+1)
 		panic(0)
 	}
 }
 
 var Lexers = map[int]*regexp.Regexp{
 	PRIM:  regexp.MustCompile(`^[[:space:]]*([$]?[[:word:].]+|'.')(.*)$`),
-	BINOP: regexp.MustCompile(`^[[:space:]]*([-+*/%^|&]|<<|>>>|>>|==|!=|<=|>=|<|>)(.*)$`),
+	BINOP: regexp.MustCompile(`^[[:space:]]*(&&|[|][|]|<<|>>>|>>|==|!=|<=|>=|[-+*/%^|&<>~!?])(.*)$`),
 	OPEN:  regexp.MustCompile(`^[[:space:]]*([(])(.*)$`),
 	CLOSE: regexp.MustCompile(`^[[:space:]]*([)])(.*)$`),
 	COLON: regexp.MustCompile(`^[[:space:]]*([:])(.*)$`),
@@ -272,6 +288,8 @@ type Evaluator struct {
 	remain string
 	tok    string
 	typ    int
+	skip   int        // inside a branch not taken, where x/0 is not an error
+	rel    *relocTerm // the part of the last value that the linker fills in
 }
 
 const (
@@ -293,7 +311,8 @@ func (ev *Evaluator) Next() {
 			return
 		}
 	}
-	ev.Fail()
+	ev.tok = "" // nothing matched
+	ev.Fail("")
 }
 
 func (ev *Evaluator) EvaluatePrim() uint {
@@ -301,37 +320,38 @@ func (ev *Evaluator) EvaluatePrim() uint {
 	ev.Next()
 
 	var value int64
+	var rel *relocTerm // for the linker to fill in
 	var err error
 	s0 := s[0]
 	if s0 == '$' {
 		value, err = strconv.ParseInt(s[1:], 16, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as hex int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as hex int", s)
 		}
 	} else if strings.HasPrefix(s, "0x") {
 		value, err = strconv.ParseInt(s[2:], 16, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as hex int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as hex int", s)
 		}
 	} else if strings.HasPrefix(s, "0o") {
 		value, err = strconv.ParseInt(s[2:], 8, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as octal int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as octal int", s)
 		}
 	} else if strings.HasPrefix(s, "0b") {
 		value, err = strconv.ParseInt(s[2:], 2, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as binary int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as binary int", s)
 		}
 	} else if '0' == s0 {
 		value, err = strconv.ParseInt(s, 8, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as octal int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as octal int", s)
 		}
 	} else if '1' <= s0 && s0 <= '9' {
 		value, err = strconv.ParseInt(s, 10, 64)
 		if err != nil {
-			log.Panicf("cannot parse %q as decimal int: %#v", s, ev.row)
+			ev.mod.errorf(ev.row, s, "cannot parse %q as decimal int", s)
 		}
 	} else if s0 == '\'' {
 		value = int64(s[1])
@@ -340,140 +360,290 @@ func (ev *Evaluator) EvaluatePrim() uint {
 			// Function call syntax.
 			ev.Next()
 			args := []uint{ev.EvaluateExpr()}
+			rels := []*relocTerm{ev.rel}
 			for ev.typ == COLON {
 				ev.Next()
 				args = append(args, ev.EvaluateExpr())
+				rels = append(rels, ev.rel)
 			}
 			if ev.typ != CLOSE {
-				panic(ev.Fail())
+				panic(ev.Fail("')'"))
 			}
 			ev.Next()
 
 			checkNumArgs := func(n int) {
 				if len(args) != n {
-					log.Panicf("Function %q expected %d args, but got %d args, (args must be separated by colons) in row: %#v", s, n, len(args), ev.row)
+					ev.mod.errorf(ev.row, s, "function %s takes %d arguments (separated by colons), not %d", s, n, len(args))
 				}
 			}
 
-			switch strings.ToLower(s) {
-			case "b":
-				checkNumArgs(1)
-				value = int64(0xFF & (args[0] >> 16))
-			case "h":
-				checkNumArgs(1)
-				value = int64(0xFF & (args[0] >> 8))
-			case "l":
-				checkNumArgs(1)
-				value = int64(0xFF & args[0])
-			case "hl":
+			switch fn := strings.ToLower(s); fn {
+			case "b", "h", "l", "hl":
 				checkNumArgs(1)
-				value = int64(0xFFFF & args[0])
+				value = int64(field(fn, args[0]))
+				if r := rels[0]; r != nil {
+					if r.field != "" {
+						ev.cannotRelocate()
+					}
+					rel = &relocTerm{symbol: r.symbol, field: fn, addend: args[0]}
+				}
 			case "w":
 				checkNumArgs(3)
 				value = int64(((0xFF & args[0]) << 16) | ((0xFF & args[1]) << 8) | (0xFF & args[2]))
+				if rels[0] != nil || rels[1] != nil || rels[2] != nil {
+					ev.cannotRelocate()
+				}
 			default:
-				log.Panicf("Unknown function called in %q, in row: %#v", s, ev.row)
+				ev.mod.errorf(ev.row, s, "unknown function %q", s)
 			}
 		} else {
 			// Must be a label.
 			lbl, ok := ev.mod.labels[s]
-			if !ok {
-				log.Panicf("Unknown label %q in row: %#v", s, ev.row)
+			if !ok && ev.mod.reloc {
+				// The linker will find it in another object.
+				ev.mod.imports[s] = true
+				lbl = &Label{reloc: true, rel: relocTerm{symbol: s}}
+			} else if !ok {
+				ev.mod.errorf(ev.row, s, "unknown label %q", s)
+			}
+			if lbl.reloc {
+				r := lbl.rel
+				rel = &r
+			}
+			if lbl.unset && ev.skip == 0 && ev.mod.undefinedSeen == "" {
+				ev.mod.undefinedSeen = s
 			}
 			value = int64(lbl.addr)
 		}
 	}
+	ev.rel = rel
 	return 0xFFFFFF & uint(value)
 }
 
-func (ev *Evaluator) Fail() bool {
-	log.Panicf("Evaluator cannot parse %q: unparsed remainder is %q: in row %#v", ev.orig, ev.remain, ev.row)
+// Fail reports that the expression cannot be parsed at the current token.
+// missing names what was expected there (like "')'"), if anything.
+func (ev *Evaluator) Fail(missing string) bool {
+	rest := strings.TrimSpace(ev.tok + ev.remain) // with the token that failed
+	switch {
+	case rest != "":
+		ev.mod.errorf(ev.row, rest, "cannot parse expression %q: unparsed remainder is %q", ev.orig, rest)
+	case missing != "":
+		ev.mod.errorf(ev.row, ev.orig, "cannot parse expression %q: missing %s", ev.orig, missing)
+	}
+	ev.mod.errorf(ev.row, ev.orig, "cannot parse expression %q: unexpected end of expression", ev.orig)
 	return false
 }
 
+// Precedence of the binary operators, as in C.
+// Higher binds tighter; all are left-associative.
+var Precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8, ">>>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// EvaluateExpr evaluates a whole expression: cond ? x : y
+// (which is right-associative, and lowest), or a binary expression.
 func (ev *Evaluator) EvaluateExpr() uint {
-	var x uint
+	cond := ev.EvaluateBinary(1)
+	if ev.typ != BINOP || ev.tok != "?" {
+		return cond
+	}
+	if ev.rel != nil {
+		ev.cannotRelocate()
+	}
+	ev.Next()
+	x := ev.evaluateBranch(cond != 0, ev.EvaluateExpr)
+	xRel := ev.rel
+	if ev.typ != COLON {
+		panic(ev.Fail("':'"))
+	}
+	ev.Next()
+	y := ev.evaluateBranch(cond == 0, ev.EvaluateExpr)
+	if cond != 0 {
+		ev.rel = xRel
+		return x
+	}
+	return y
+}
+
+// evaluateBranch evaluates with fn, noting if the branch is not taken.
+func (ev *Evaluator) evaluateBranch(taken bool, fn func() uint) uint {
+	if !taken {
+		ev.skip++
+		defer func() { ev.skip-- }()
+	}
+	return fn()
+}
+
+// EvaluateBinary evaluates operators of at least the given precedence,
+// by precedence climbing.
+func (ev *Evaluator) EvaluateBinary(precedence int) uint {
+	x := ev.EvaluateUnary()
+	for ev.typ == BINOP && Precedence[ev.tok] >= precedence {
+		binop := ev.tok
+		xRel := ev.rel
+		ev.Next()
+		higher := func() uint { return ev.EvaluateBinary(Precedence[binop] + 1) }
+		var y uint
+		switch binop {
+		case "&&": // y does not matter, if x is false
+			y = ev.evaluateBranch(x != 0, higher)
+		case "||": // y does not matter, if x is true
+			y = ev.evaluateBranch(x == 0, higher)
+		default:
+			y = higher()
+		}
+		x = ev.Apply(binop, x, y)
+		ev.rel = ev.relocate(binop, xRel, ev.rel)
+	}
+	return x
+}
+
+// relocate finds what the linker fills in, for x binop y,
+// where x and y are relocated by xRel and yRel.
+// The linker only adds to the address of a label, or takes
+// a field of that, but the same address cancels out.
+func (ev *Evaluator) relocate(binop string, xRel, yRel *relocTerm) *relocTerm {
+	switch {
+	case xRel == nil && yRel == nil:
+		return nil
+	case xRel != nil && xRel.field != "", yRel != nil && yRel.field != "":
+		// a field cannot be added to
+	case binop == "+" && xRel == nil:
+		return yRel
+	case (binop == "+" || binop == "-") && yRel == nil:
+		return xRel
+	case xRel != nil && yRel != nil && xRel.symbol == yRel.symbol:
+		switch binop {
+		case "-", "==", "!=", "<", "<=", ">", ">=":
+			return nil
+		}
+	}
+	ev.cannotRelocate()
+	return nil
+}
+
+// cannotRelocate reports an expression that the linker cannot fill in.
+func (ev *Evaluator) cannotRelocate() {
+	ev.mod.errorf(ev.row, ev.orig, "cannot relocate %q: the linker can only add to a relocatable or imported label, or take B, H, L or HL of that", ev.orig)
+}
 
+// EvaluateUnary evaluates a primary (a number, label, function call,
+// or parenthesized expression), after any unary operators.
+func (ev *Evaluator) EvaluateUnary() uint {
 	switch ev.typ {
+	case BINOP:
+		unop := ev.tok
+		ev.Next()
+		x := ev.EvaluateUnary()
+		if ev.rel != nil {
+			ev.cannotRelocate()
+		}
+		switch unop {
+		case "-":
+			return (0 - x) & 0xFFFFFF
+		case "~":
+			return x ^ 0xFFFFFF
+		case "!":
+			return Truth(x == 0)
+		}
+		ev.mod.errorf(ev.row, unop, "cannot parse expression %q: %q is not a unary operator", ev.orig, unop)
 	case OPEN:
 		ev.Next()
-		x = ev.EvaluateExpr()
+		x := ev.EvaluateExpr()
 		if ev.typ != CLOSE {
-			panic(ev.Fail())
+			panic(ev.Fail("')'"))
 		}
 		ev.Next()
-
+		return x
 	case PRIM:
-		x = ev.EvaluatePrim()
-	default:
-		panic(ev.Fail())
+		return ev.EvaluatePrim()
 	}
+	panic(ev.Fail(""))
+}
 
-	if ev.typ == BINOP {
-		binop := ev.tok
-		var y uint
-		ev.Next()
-		switch ev.typ {
-		case OPEN:
-			y = ev.EvaluateExpr()
-			//if ev.typ != CLOSE {
-			//panic(ev.Fail())
-			//}
-			//ev.Next()
-		case PRIM:
-			y = ev.EvaluatePrim()
-		default:
-			panic(ev.Fail())
+// Apply applies a binary operator, in 24-bit unsigned arithmetic.
+func (ev *Evaluator) Apply(binop string, x, y uint) uint {
+	switch binop {
+	case "+":
+		x = x + y
+	case "-":
+		x = x - y
+	case "*":
+		x = x * y
+	case "/", "%":
+		if y == 0 {
+			if ev.skip > 0 || ev.mod.undefinedSeen != "" {
+				return 0 // in a branch not taken, or using a label with no value yet
+			}
+			ev.mod.errorf(ev.row, binop, "division by zero, in %q", ev.orig)
 		}
-
-		switch binop {
-		case "+":
-			x = x + y
-		case "-":
-			x = x - y
-		case "*":
-			x = x * y
-		case "/":
+		if binop == "/" {
 			x = x / y
-		case "%":
+		} else {
 			x = x % y
-		case "&":
-			x = x & y
-		case "|":
-			x = x | y
-		case "^":
-			x = x ^ y
-		case "<<":
-			x = x << y
-		case ">>>": // unsigned: shifts zeros in
+		}
+	case "&":
+		x = x & y
+	case "|":
+		x = x | y
+	case "^":
+		x = x ^ y
+	case "<<":
+		x = x << y
+	case ">>>": // unsigned: shifts zeros in
+		x = x >> y
+	case ">>": // signed: sign bit replicates
+		// NOTA BENE: 24-bit arithmetic.
+		if (x & 0x800000) == 0 {
 			x = x >> y
-		case ">>": // signed: sign bit replicates
-			// NOTA BENE: 24-bit arithmetic.
-			if (x & 0x800000) == 0 {
-				x = x >> y
-			} else {
-				x = (x | ^uint(0x7FFFFF)) >> y
-			}
-		case "==":
-			x = Truth(x == y)
-		case "!=":
-			x = Truth(x != y)
-		case "<=":
-			x = Truth(x <= y)
-		case ">=":
-			x = Truth(x >= y)
-		case "<":
-			x = Truth(x < y)
-		case ">":
-			x = Truth(x > y)
-		default:
-			log.Panicf("Evaluator cannot parse %q: unknown binary operator %q: in row $#v", ev.orig, binop, ev.row)
+		} else {
+			x = (x | ^uint(0x7FFFFF)) >> y
 		}
+	case "==":
+		x = Truth(x == y)
+	case "!=":
+		x = Truth(x != y)
+	case "<=":
+		x = Truth(x <= y)
+	case ">=":
+		x = Truth(x >= y)
+	case "<":
+		x = Truth(x < y)
+	case ">":
+		x = Truth(x > y)
+	case "&&":
+		x = Truth(x != 0 && y != 0)
+	case "||":
+		x = Truth(x != 0 || y != 0)
+	default:
+		ev.mod.errorf(ev.row, binop, "cannot parse expression %q: unknown binary operator %q", ev.orig, binop)
 	}
-
 	return x & 0xFFFFFF
 }
 
+// field takes a field of the 24-bit address x,
+// as the functions B, H, L and HL do.
+func field(name string, x uint) uint {
+	switch name {
+	case "b":
+		return 0xFF & (x >> 16)
+	case "h":
+		return 0xFF & (x >> 8)
+	case "l":
+		return 0xFF & x
+	}
+	return 0xFFFF & x // "hl"
+}
+
 func Truth(b bool) uint {
 	if b {
 		return 1
@@ -483,6 +653,7 @@ func Truth(b bool) uint {
 }
 
 func (mod *Mod) Evaluate(row *Row, s string) uint {
+	mod.undefinedSeen = ""
 	ev := &Evaluator{
 		mod:    mod,
 		row:    row,
@@ -493,18 +664,63 @@ func (mod *Mod) Evaluate(row *Row, s string) uint {
 	ev.Next()
 	x := ev.EvaluateExpr()
 	if ev.typ != END {
-		panic(ev.Fail())
+		panic(ev.Fail(""))
 	}
+	mod.lastReloc = ev.rel
+	return x
+}
+
+// evaluateAbsolute is Evaluate for things that must be known
+// before linking, like the argument of ORG.
+func (mod *Mod) evaluateAbsolute(row *Row, s string) uint {
+	x := mod.Evaluate(row, s)
+	mod.checkAbsolute(row, s)
 	return x
 }
 
+// checkAbsolute reports if the last Evaluate for the row
+// needs the linker, where that is not allowed.
+func (mod *Mod) checkAbsolute(row *Row, s string) {
+	if mod.lastReloc != nil {
+		mod.errorf(row, s, "%s cannot use relocatable or imported labels", strings.ToUpper(row.opcode))
+	}
+}
+
+// checkDefined reports if the last Evaluate for the row used a label
+// with no value yet.  If so, it is an error, unless PassTwo assigns
+// addresses again.
+func (mod *Mod) checkDefined(row *Row) bool {
+	name := mod.undefinedSeen
+	if name == "" {
+		return false
+	}
+	mod.report(diagnostic(SevError, row, name, "%s depends on %q, which never gets a value (it depends on itself)", strings.ToUpper(row.opcode), name))
+	return true
+}
+
 func (mod *Mod) EvalArg(row *Row, i int) uint {
+	value := mod.evalArg(row, i)
+	mod.checkAbsolute(row, row.args[i])
+	return value
+}
+
+// evalArgAt is EvalArg for an argument generated as size bytes at addr.
+// In an object, the linker fills them in, if it is relocatable.
+func (mod *Mod) evalArgAt(row *Row, i int, addr uint, size uint) uint {
+	value := mod.evalArg(row, i)
+	if mod.lastReloc != nil {
+		mod.object.relocate(row, addr, size, *mod.lastReloc, value)
+	}
+	return value
+}
+
+func (mod *Mod) evalArg(row *Row, i int) uint {
 	if len(row.args) < i+1 {
-		log.Panicf("not enough args: %d: %#v", i, row)
+		mod.errorf(row, "", "missing argument %d", i+1)
 	}
 	argStr := strings.TrimSpace(row.args[i])
 	if argStr == "" {
-		log.Panicf("empty arg %d: %#v", i, row)
+		mod.errorf(row, "", "empty argument %d", i+1)
 	}
 	return mod.Evaluate(row, argStr)
 }
@@ -521,6 +737,9 @@ var LinePattern = regexp.MustCompile(
 
 // PassThree generates code.
 func PassThree(mod *Mod) {
+	if !mod.reloc {
+		mod.owners = new(ownership)
+	}
 	for i, row := range mod.rows {
 		if row.opcode == "" {
 			if mod.listing != nil {
@@ -536,120 +755,211 @@ func PassThree(mod *Mod) {
 			log.Panicf("Row %d not final: %#v", i, row)
 		}
 		if row.instr != nil && row.instr.generate != nil {
-			row.instr.generate(mod, row)
+			tryRow(func() {
+				if mod.reloc {
+					mod.generateReloc(row)
+				} else {
+					row.instr.generate(mod, row)
+				}
+			})
 		}
 	}
+	if mod.overlay != nil {
+		tryRow(func() {
+			mod.errorf(mod.overlay, "", "OVERLAY without ENDOVERLAY")
+		})
+	}
 }
 
-// PassTwo assigns addresses.
+// PassTwo assigns addresses.  ORG, RMB, EQU and ROW counts may use
+// labels defined after them, so it assigns them again, until no label
+// changes.  Only errors from that last pass count, as earlier passes
+// see labels with no value yet.  A label that never gets a value is
+// defined in terms of itself, and the pseudo-ops using it are errors.
 func PassTwo(mod *Mod) {
+	diags := mod.diags
+	defer func() { mod.diags = diags }()
+	if mod.maxPasses == 0 {
+		mod.maxPasses = len(mod.rows) + 1
+	}
+	for pass := 1; ; pass++ {
+		before := make(map[string]Label, len(mod.labels))
+		for name, lab := range mod.labels {
+			before[name] = *lab
+		}
+		mod.diags = &Diagnostics{}
+		mod.currentRow, mod.currentBank, mod.rowsBank = 0, 0, 0
+		mod.assignAddresses()
+		passDiags := mod.diags
+		mod.diags = diags
+
+		changed := make(map[string]bool)
+		for name, lab := range mod.labels {
+			was := before[name]
+			if *lab != was && !(lab.unset && was.unset) { // a label with no value has no value to change
+				changed[name] = true
+			}
+		}
+		if len(changed) == 0 {
+			for _, d := range passDiags.List {
+				mod.report(d)
+			}
+			return
+		}
+		if pass < mod.maxPasses {
+			continue
+		}
+		// The errors of this pass might not be real, so report only the labels.
+		for _, row := range mod.rows {
+			if changed[row.label] {
+				delete(changed, row.label) // report each label once
+				was, is := before[row.label], mod.labels[row.label]
+				mod.report(diagnostic(SevError, row, row.label, "label %q does not converge after %d passes (it went from $%06x to $%06x)", row.label, pass, was.addr, is.addr))
+			}
+		}
+		return
+	}
+}
+
+// assignAddresses assigns the addresses of the rows, once.
+// After an ORG or RMB using a label with no value,
+// addresses have no value either.
+func (mod *Mod) assignAddresses() {
 	addr := uint(0)
+	unset := false // addr has no value yet
 	for _, row := range mod.rows {
-		if row.length == 0 && row.opcode != "" {
-			// Special Cases: ORG, RMB...
-			if row.opcode == "org" {
-				if len(row.args) != 1 {
-					log.Panicf("Pseudo-opcode ORG needs one argument, in row: %#v", row)
-				}
-				addr = mod.Evaluate(row, row.args[0])
-				row.addr = addr
-				row.final = true // addr is final
-				if row.label != "" {
-					lab := mod.labels[row.label]
-					lab.addr = addr
-				}
-			} else if row.opcode == "rmb" {
-				if len(row.args) != 1 {
-					log.Panicf("Pseudo-opcode RMB needs one argument, in row: %#v", row)
+		tryRow(func() {
+			if row.length == 0 && row.opcode != "" {
+				// Special Cases: ORG, RMB...
+				if row.opcode == "org" {
+					if len(row.args) != 1 {
+						mod.errorf(row, "", "ORG needs one argument")
+					}
+					addr = mod.evaluateAbsolute(row, row.args[0])
+					unset = mod.checkDefined(row)
+					row.addr = addr
+					row.final = true // addr is final
+					if row.label != "" {
+						lab := mod.labels[row.label]
+						lab.addr, lab.unset = addr, unset
+					}
+				} else if row.opcode == "rmb" {
+					if len(row.args) != 1 {
+						mod.errorf(row, "", "RMB needs one argument")
+					}
+					row.length = mod.evaluateAbsolute(row, row.args[0])
+					lengthUnset := mod.checkDefined(row)
+					row.addr = addr
+					row.final = true // addr is final
+					if row.label != "" {
+						lab := mod.labels[row.label]
+						lab.addr, lab.unset = addr, unset
+					}
+					addr += row.length
+					unset = unset || lengthUnset
+				} else if row.opcode == "row" {
+					row.length = 0
+					numRows := uint(1) // default
+					if len(row.args) == 2 {
+						numRows = mod.evaluateAbsolute(row, row.args[1])
+						mod.checkDefined(row)
+					}
+					mod.currentRow += numRows
+					if mod.currentRow > 255 {
+						mod.errorf(row, "", "too many ROW tables allocated")
+					}
+					row.addr = mod.currentRow - numRows
+					row.final = true // addr is final
+					if row.label != "" {
+						lab := mod.labels[row.label]
+						lab.addr, lab.unset = addr, unset
+					}
+				} else if row.opcode == "bank" {
+					row.length = 0
+					mod.currentBank++
+					if mod.currentBank > 15 { // assuming 1MB RAM
+						mod.errorf(row, "", "too many BANK tables allocated (the RAM has 15 banks after bank 0)")
+					}
+					row.addr = mod.currentBank
+					if row.args[0] == "" && mod.rowsBank == 0 {
+						// Special BANK allocation with no arguments is the Rows Bank.
+						mod.rowsBank = mod.currentBank
+					}
+					row.final = true // addr is final
+					if row.label != "" {
+						lab := mod.labels[row.label]
+						lab.addr, lab.unset = addr, unset
+					}
+				} else if row.opcode == "equ" {
+					if len(row.args) != 1 {
+						mod.errorf(row, "", "EQU needs one argument")
+					}
+					row.addr = mod.Evaluate(row, row.args[0])
+					row.final = true // addr is final
+					if row.label != "" {
+						lab := mod.labels[row.label]
+						lab.addr = row.addr
+						lab.reloc, lab.rel = mod.lastReloc != nil, relocTerm{}
+						if lab.reloc {
+							lab.rel = *mod.lastReloc
+						}
+						lab.unset = mod.checkDefined(row)
+					}
+				} else if row.opcode == "generable" || row.opcode == "overlay" || row.opcode == "endoverlay" {
+					row.addr = addr
+					row.final = true // addr is final
+				} else if row.opcode == "assert" {
+					if len(row.args) != 1 {
+						mod.errorf(row, "", "ASSERT needs one argument")
+					}
+					row.addr = 1
+					row.final = true // addr is final
+				} else {
+					log.Panicf("Unknown pseudo-opcode %q has 0 length, in row: %#v", row.opcode, row)
 				}
-				row.length = mod.Evaluate(row, row.args[0])
+			} else {
+				// Normal fixed-length instructions
+				// and non-generating lines (like just a label or comment)
 				row.addr = addr
 				row.final = true // addr is final
 				if row.label != "" {
 					lab := mod.labels[row.label]
-					lab.addr = addr
+					lab.addr, lab.unset = addr, unset
 				}
 				addr += row.length
-			} else if row.opcode == "row" {
-				row.length = 0
-				numRows := uint(1) // default
-				if len(row.args) == 2 {
-					numRows = mod.Evaluate(row, row.args[1])
-				}
-				mod.currentRow += numRows
-				if mod.currentRow > 255 {
-					log.Panicf("Too many ROW TABLES allocated, in row: %#v", row)
-				}
-				row.addr = mod.currentRow - numRows
-				row.final = true // addr is final
-				if row.label != "" {
-					lab := mod.labels[row.label]
-					lab.addr = addr
-				}
-			} else if row.opcode == "bank" {
-				row.length = 0
-				mod.currentBank++
-				if mod.currentBank > 15 { // assuming 1MB RAM
-					log.Panicf("Too many BANK TABLES allocated, in row: %#v", row)
-				}
-				row.addr = mod.currentBank
-				if row.args[0] == "" && mod.rowsBank == 0 {
-					// Special BANK allocation with no arguments is the Rows Bank.
-					mod.rowsBank = mod.currentBank
-				}
-				row.final = true // addr is final
-				if row.label != "" {
-					lab := mod.labels[row.label]
-					lab.addr = addr
-				}
-			} else if row.opcode == "equ" {
-				if len(row.args) != 1 {
-					log.Panicf("Pseudo-opcode EQU needs one argument, in row: %#v", row)
-				}
-				row.addr = mod.Evaluate(row, row.args[0])
-				row.final = true // addr is final
-				if row.label != "" {
-					lab := mod.labels[row.label]
-					lab.addr = row.addr
-				}
-			} else if row.opcode == "assert" {
-				if len(row.args) != 1 {
-					log.Panicf("Pseudo-opcode ASSERT needs one argument, in row: %#v", row)
-				}
-				row.addr = 1
-				row.final = true // addr is final
-			} else {
-				log.Panicf("Uknown pseudo-opcode %q has 0 length, in row: $#v", row.opcode, row)
 			}
-		} else {
-			// Normal fixed-length instructions
-			// and non-generating lines (like just a label or comment)
-			row.addr = addr
-			row.final = true // addr is final
-			if row.label != "" {
-				lab := mod.labels[row.label]
-				lab.addr = addr
-			}
-			addr += row.length
-		}
+		})
 	}
 }
 
 // PassOne creates labels and looks up instructions by opcode.
+// In an object, labels before the first ORG are relocatable.
 func PassOne(mod *Mod) {
-	for i, row := range mod.rows {
+	relocatable := mod.reloc
+	defined := make(map[string]*Row)
+	for _, row := range mod.rows {
+		if row.opcode == "org" {
+			relocatable = false
+		}
 		if row.label != "" {
-			mod.labels[row.label] = &Label{}
+			if first, ok := defined[row.label]; ok {
+				mod.warnf(row, row.label, "label %q redefined (the first is at %s); the last one wins", row.label, first.where)
+			} else {
+				defined[row.label] = row
+			}
+			mod.labels[row.label] = &Label{reloc: relocatable && row.opcode != "equ", unset: true}
 		}
 		if row.opcode == "" {
 			continue
 		}
-		instr, ok := Instructions[row.opcode]
-		if !ok {
-			log.Panicf("Unknown opcode on line %d: %q", i+1, row.opcode)
-		}
-		row.instr = instr
-		row.length = instr.length
+		tryRow(func() {
+			instr, ok := Instructions[row.opcode]
+			if !ok {
+				mod.errorf(row, row.opcode, "unknown opcode %q", row.opcode)
+			}
+			row.instr = instr
+			row.length = instr.length
+		})
 	}
 }
 
@@ -675,7 +985,7 @@ func MacroPassTwo(mod *Mod) {
 			if ok {
 				// Enter the macro.  Check it is not active, and mark it active.
 				if active, _ := activeMacros[row_.opcode]; active {
-					log.Panicf("Infinite recursion detected: MACRO %q is already active: %#v", row_.opcode, activeMacros)
+					mod.errorf(row_, row_.opcode, "MACRO %s expands itself, forever", row_.opcode)
 				}
 				activeMacros[row_.opcode] = true
 
@@ -729,7 +1039,7 @@ func MacroPassTwo(mod *Mod) {
 			}
 
 		}
-		recursiveAppendSimpleOpcodeOrMacroExpansion(row)
+		tryRow(func() { recursiveAppendSimpleOpcodeOrMacroExpansion(row) })
 	}
 	mod.rows = newRows // with the macros expanded
 }
@@ -737,6 +1047,7 @@ func MacroPassTwo(mod *Mod) {
 // MacroPassOne creates macros.
 func MacroPassOne(mod *Mod) {
 	var macro *Macro
+	defined := make(map[string]*Row)
 
 	var newRows []*Row
 	for _, row := range mod.rows {
@@ -745,12 +1056,19 @@ func MacroPassOne(mod *Mod) {
 			if row.opcode == "macro" {
 				// Starts a macro definition
 				if row.label == "" {
-					log.Panicf("Cannot define a macro without a label: %#v", row)
+					tryRow(func() { mod.errorf(row, "", "MACRO needs a label, to name it") })
+					continue
 				}
 				macro = &Macro{
 					formals: row.args,
 				}
-				mod.macros[strings.ToLower(row.label)] = macro
+				name := strings.ToLower(row.label)
+				if first, ok := defined[name]; ok {
+					mod.warnf(row, row.label, "MACRO %s redefined (the first is at %s); the last one wins", row.label, first.where)
+				} else {
+					defined[name] = row
+				}
+				mod.macros[name] = macro
 
 				newRows = append(newRows, &Row{
 					comment: fmt.Sprintf("; MACRO DEFINITION"),
@@ -807,6 +1125,7 @@ func ParseLine(line string) *Row {
 		opcode:  strings.ToLower(opcode),
 		args:    SplitOnCommaAndTrim(args),
 		comment: comment,
+		line:    line,
 	}
 	// Log("      Row -> %#v", *row)
 	return row
@@ -826,59 +1145,117 @@ func ParseLines(lines []string, wheres []string) *Mod {
 }
 
 func SlurpTextFile(filename string) (lines []string) {
-	file, err := os.Open(filename)
+	lines, err := readTextFile(filename)
 	if err != nil {
 		log.Panicf("Cannot SlurpTextFile %q: %v", filename, err)
 	}
+	return lines
+}
+
+// readTextFile reads the lines of a text file.
+func readTextFile(filename string) (lines []string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
+	return lines, scanner.Err()
+}
+
+// AssembleFiles reads the source files in order,
+// and runs all the passes of the assembler on them,
+// writing the listing to listing, if it is not nil.
+func AssembleFiles(filenames []string, listing io.Writer) *Mod {
+	lines, wheres := SlurpSources(filenames)
+	return AssembleLines(lines, wheres, listing)
+}
+
+// SlurpSources reads the source files in order,
+// naming the source location of each line in wheres.
+func SlurpSources(filenames []string) (lines []string, wheres []string) {
+	diags := &Diagnostics{}
+	lines, wheres = ReadSources(filenames, diags)
+	if diags.Errors() > 0 {
+		log.Panicf("%s", diags)
+	}
+	return
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+// ReadSources is SlurpSources, but reports files it cannot read
+// in diags, and goes on to the next file.
+func ReadSources(filenames []string, diags *Diagnostics) (lines []string, wheres []string) {
+	for _, filename := range filenames {
+		slurp, err := readTextFile(filename)
+		if err != nil {
+			diags.List = append(diags.List, FileDiagnostic(filename, err))
+			continue
+		}
+		lines = append(lines, slurp...)
+		for i := 1; i <= len(slurp); i++ {
+			wheres = append(wheres, fmt.Sprintf("%s:%d", filename, i))
+		}
 	}
 	return
 }
 
+// AssembleLines runs all the passes of the assembler on the lines,
+// where wheres names the source location of each line.
+// It panics with the Diagnostics, if there are errors.
+func AssembleLines(lines []string, wheres []string, listing io.Writer) *Mod {
+	diags := &Diagnostics{}
+	mod := Assemble(lines, wheres, listing, diags)
+	if diags.Errors() > 0 {
+		log.Panicf("%s", diags)
+	}
+	return mod
+}
+
+// Assemble is AssembleLines, but collects errors and warnings in diags.
+// If there are errors, the Mod is not complete.
+func Assemble(lines []string, wheres []string, listing io.Writer, diags *Diagnostics) *Mod {
+	mod := ParseLines(lines, wheres)
+	mod.listing = listing
+	mod.diags = diags
+	mod.runPasses(MacroPassOne, MacroPassTwo, PassOne, PassTwo, PassThree)
+	mod.diags = nil
+	return mod
+}
+
 func CreateIPL(mod *Mod) []byte {
 	var ipl []byte
 	current := uint(0xFFFFFFFF) // will not match any addr
 
 	for _, pair := range mod.generated {
 		if pair.addr == current {
-			ipl = append(ipl, 0x0A /*incw*/, 0)
+			ipl = append(ipl, Encode("incw"), 0)
 		} else {
 			b, h, l := BhlSplit(pair.addr)
 			ipl = append(ipl,
-				0x05 /*setb*/, b,
-				0x06 /*seth*/, h,
-				0x07 /*setl*/, l)
+				Encode("setb"), b,
+				Encode("seth"), h,
+				Encode("setl"), l)
 		}
 		ipl = append(ipl,
-			0x04 /*seta*/, pair.data,
-			0x44 /*mv a,m*/, 0)
+			Encode("seta"), pair.data,
+			Encode("mv", 0, 4), 0)
 		current = pair.addr + 1
 	}
 
-	start := uint(0)
-	start_label, ok := mod.labels["start"]
-	if ok {
-		start = start_label.addr
-	}
-
-	b, h, l := BhlSplit(start)
+	b, h, l := BhlSplit(ModStart(mod))
 	ipl = append(ipl,
-		0x04 /*seta*/, 1, // enable jump
-		0x05 /*setb*/, b, // start address
-		0x06 /*seth*/, h,
-		0x07 /*setl*/, l,
-		0x0C /*bnz*/, 0,
-		0x0C /*bnz*/, 0, // do it 4 times,
-		0x0C /*bnz*/, 0, // just so "hd ipl" looks prettier.
-		0x0C /*bnz*/, 0)
+		Encode("seta"), 1, // enable jump
+		Encode("setb"), b, // start address
+		Encode("seth"), h,
+		Encode("setl"), l,
+		Encode("bnz"), 0,
+		Encode("bnz"), 0, // do it 4 times,
+		Encode("bnz"), 0, // just so "hd ipl" looks prettier.
+		Encode("bnz"), 0)
 	return ipl
 }
 
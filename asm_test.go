@@ -2,6 +2,7 @@ package ABhL // pronounced "owl"
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -13,19 +14,19 @@ func Test1(t *testing.T) {
 	for _, it := range []struct{ line, want string }{
 		{
 			"abhc",
-			`&ABhL.Row{label:"abhc", opcode:"", args:[]string{""}, comment:"", instr:(*ABhL.Instr)(nil), length:0x0, addrX:(*ABhL.Expr)(nil), addr:0x0, final:false}`,
+			`&ABhL.Row{label:"abhc", opcode:"", args:[]string{""}, comment:"", instr:(*ABhL.Instr)(nil), length:0x0, addr:0x0, final:false, where:"", line:"abhc"}`,
 		},
 		{
 			"abhc: ; foo the bar",
-			`&ABhL.Row{label:"abhc", opcode:"", args:[]string{""}, comment:"; foo the bar", instr:(*ABhL.Instr)(nil), length:0x0, addrX:(*ABhL.Expr)(nil), addr:0x0, final:false}`,
+			`&ABhL.Row{label:"abhc", opcode:"", args:[]string{""}, comment:"; foo the bar", instr:(*ABhL.Instr)(nil), length:0x0, addr:0x0, final:false, where:"", line:"abhc: ; foo the bar"}`,
 		},
 		{
 			"abhc LDA #90, y ; remark",
-			`&ABhL.Row{label:"abhc", opcode:"LDA", args:[]string{"#90", " y "}, comment:"; remark", instr:(*ABhL.Instr)(nil), length:0x0, addrX:(*ABhL.Expr)(nil), addr:0x0, final:false}`,
+			`&ABhL.Row{label:"abhc", opcode:"lda", args:[]string{"#90", "y"}, comment:"; remark", instr:(*ABhL.Instr)(nil), length:0x0, addr:0x0, final:false, where:"", line:"abhc LDA #90, y ; remark"}`,
 		},
 		{
 			"abhc: bcd one,two,three;eight,nine,ten",
-			`&ABhL.Row{label:"abhc", opcode:"bcd", args:[]string{"one", "two", "three"}, comment:";eight,nine,ten", instr:(*ABhL.Instr)(nil), length:0x0, addrX:(*ABhL.Expr)(nil), addr:0x0, final:false}`,
+			`&ABhL.Row{label:"abhc", opcode:"bcd", args:[]string{"one", "two", "three"}, comment:";eight,nine,ten", instr:(*ABhL.Instr)(nil), length:0x0, addr:0x0, final:false, where:"", line:"abhc: bcd one,two,three;eight,nine,ten"}`,
 		},
 	} {
 		got := Repr(ParseLine(it.line))
@@ -37,3 +38,207 @@ func Test1(t *testing.T) {
 		}
 	}
 }
+
+// evalText evaluates the expression, with labels ten and big,
+// returning the value, or the error it panics with.
+func evalText(s string) (value uint, msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	mod := &Mod{labels: map[string]*Label{
+		"ten": {addr: 10},
+		"big": {addr: 0x800010},
+	}}
+	row := &Row{opcode: "equ", args: []string{s}, where: "expr:1", line: "x\tequ " + s}
+	return mod.Evaluate(row, s), ""
+}
+
+func TestExpressions(t *testing.T) {
+	for _, it := range []struct {
+		expr string
+		want uint
+	}{
+		// precedence, as in C
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 * 3 + 1", 7},
+		{"1 + 2 == 3", 1},
+		{"1 << 2 + 1", 8},
+		{"1 | 6 & 3", 3},
+		{"1 ^ 3 & 1", 0},
+		{"6 & 3 == 3", 0}, // & is looser than ==
+		{"1 < 2 == 2 > 1", 1},
+		{"ten - 2 * 3 - 1", 3},
+		{"ten % 4 * 3", 6},
+		{"$123456 & $00ff00 | $ff", 0x0034ff},
+
+		// left-associative
+		{"10 - 3 - 2", 5},
+		{"100 / 10 / 5", 2},
+		{"1 << 2 << 3", 32},
+
+		// unary operators
+		{"-1", 0xFFFFFF},
+		{"-ten", 0xFFFFF6},
+		{"- -ten", 10},
+		{"~0", 0xFFFFFF},
+		{"~$00ff00", 0xFF00FF},
+		{"!0", 1},
+		{"!ten", 0},
+		{"!!ten", 1},
+		{"-2 * 3", 0xFFFFFA},
+		{"-(2 * 3)", 0xFFFFFA},
+		{"~ten & $ff", 0xF5},
+		{"L(-1)", 0xFF},
+		{"W(1:-1:2)", 0x01FF02},
+
+		// logical operators
+		{"1 && 2", 1},
+		{"1 && 0", 0},
+		{"0 || 0", 0},
+		{"0 || 7", 1},
+		{"0 && 1 || 1", 1},
+		{"1 || 0 && 0", 1}, // && is tighter than ||
+		{"ten > 5 && ten < 20", 1},
+
+		// the conditional operator, right-associative
+		{"1 ? 2 : 3", 2},
+		{"0 ? 2 : 3", 3},
+		{"ten > 5 ? ten : 5", 10},
+		{"0 ? 1 : 0 ? 2 : 3", 3},
+		{"1 ? 0 ? 4 : 5 : 6", 5},
+		{"(1 ? 2 : 3) + 1", 3},
+		{"1 ? 2 : 3 + 1", 2},
+		{"W(1 ? 2 : 3 : 4 : 0 ? 5 : 6)", 0x020406},
+
+		// a branch not taken may divide by zero
+		{"0 ? 1 / 0 : 9", 9},
+		{"ten != 0 && 100 / ten == 10", 1},
+		{"0 && 1 / 0", 0},
+		{"1 || 1 % 0", 1},
+
+		// 24-bit unsigned
+		{"$ffffff + 1", 0},
+		{"0 - 1", 0xFFFFFF},
+		{"-1 > 1", 1},
+		{"$800000 * 2", 0},
+		{"1 << 24", 0},
+
+		// >>> shifts zeros in; >> replicates the sign bit
+		{"big >>> 4", 0x080001},
+		{"big >> 4", 0xF80001},
+		{"-16 >> 2", 0xFFFFFC},
+		{"-16 >>> 2", 0x3FFFFC},
+		{"ten >> 1", 5},
+		{"ten >> 1 + 1", 2},
+	} {
+		got, msg := evalText(it.expr)
+		if msg != "" || got != it.want {
+			t.Errorf("%q: got $%06x (%s), want $%06x", it.expr, got, msg, it.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, it := range []struct{ expr, want string }{
+		{"1 / 0", `expr:1:9: error: division by zero, in "1 / 0"`},
+		{"ten % (ten - 10)", `expr:1:11: error: division by zero, in "ten % (ten - 10)"`},
+		{"1 ? 1 / 0 : 2", `expr:1:13: error: division by zero, in "1 ? 1 / 0 : 2"`},
+		{"* 2", `expr:1:7: error: cannot parse expression "* 2": "*" is not a unary operator`},
+		{"1 ? 2", `expr:1:7: error: cannot parse expression "1 ? 2": missing ':'`},
+		{"1 +", `expr:1:7: error: cannot parse expression "1 +": unexpected end of expression`},
+		{"(1 + 2", `expr:1:7: error: cannot parse expression "(1 + 2": missing ')'`},
+		{"B(1", `expr:1:7: error: cannot parse expression "B(1": missing ')'`},
+		{"1 2", `expr:1:9: error: cannot parse expression "1 2": unparsed remainder is "2"`},
+		{"0 ? nowhere : 1", `expr:1:11: error: unknown label "nowhere"`},
+	} {
+		_, msg := evalText(it.expr)
+		if msg != it.want {
+			t.Errorf("%q: got %q, want %q", it.expr, msg, it.want)
+		}
+	}
+}
+
+// TestForwardReferences checks that ORG, RMB, EQU and ROW counts
+// may use labels defined after them.
+func TestForwardReferences(t *testing.T) {
+	mod := assembleText(`a	equ b+1
+b	equ c+1
+c	equ 5
+q	equ 100 / n
+n	equ 5
+	org base
+start	fcb 1
+buf	rmb size
+end	fcb 2
+	bank
+T	row _L_, rows
+U	row _L_+1
+	org (end + 1)
+after	fcb 3
+size	equ 4
+base	equ $200
+rows	equ 2`)
+	for name, want := range map[string]uint{
+		"a": 7, "q": 20, "start": 0x200, "buf": 0x201, "end": 0x205, "after": 0x206,
+	} {
+		if got, _ := mod.LabelAddr(name); got != want {
+			t.Errorf("%s is $%x, want $%x", name, got, want)
+		}
+	}
+	// U is allocated after the two rows of T.
+	mem := make(map[uint]byte)
+	for _, seg := range ModSegments(mod) {
+		for i, b := range seg.Data {
+			mem[seg.Addr+uint(i)] = b
+		}
+	}
+	if mem[0x10205] != 6 || mem[0x10101] != 1 {
+		t.Errorf("ROW tables are misplaced: %x %x", mem[0x10205], mem[0x10101])
+	}
+}
+
+func TestCircularReferences(t *testing.T) {
+	diags := diagnose(`a	equ b
+b	equ a+1
+	org here
+here	fcb 1
+	rmb len
+len	equ 1`, 0)
+	want := `bad.owl:1:7: error: EQU depends on "b", which never gets a value (it depends on itself)
+bad.owl:2:7: error: EQU depends on "a", which never gets a value (it depends on itself)
+bad.owl:3:6: error: ORG depends on "here", which never gets a value (it depends on itself)
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNoConvergence(t *testing.T) {
+	lines, wheres := sourceLines("bad.owl", "a\tequ b+1\nb\tequ c+1\nc\tequ 5\n")
+	mod := ParseLines(lines, wheres)
+	mod.diags = &Diagnostics{}
+	mod.maxPasses = 2 // too few for the chain of forward references
+	mod.runPasses(MacroPassOne, MacroPassTwo, PassOne, PassTwo)
+	diags := mod.diags
+	want := `bad.owl:2:1: error: label "b" does not converge after 2 passes (it went from $000001 to $000006)
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestLongForwardChain checks that the limit on passes,
+// from the number of rows, is enough for any chain.
+func TestLongForwardChain(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&text, "l%d\tequ l%d+1\n", i, i+1)
+	}
+	text.WriteString("l50\tequ 0\n\tfcb l0\n")
+	if diags := diagnose(text.String(), 0); len(diags.List) != 0 {
+		t.Errorf("got\n%s", diags)
+	}
+}
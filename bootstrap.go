@@ -0,0 +1,283 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// A bootstrap builds BANK and ROW tables in RAM, on the machine itself,
+// so the IPL need not load every byte of them (see owl-asm -bootstrap).
+// Tables are marked for it with the GENERABLE pseudo-op:
+//
+//	generable AddBank, NegRow
+//
+// The IPL loads a small interpreter into a free bank, with seed data:
+// records of two bytes, the low address byte of a handler and its operand.
+// The handlers set the target address (a byte of it at a time) or the
+// current value, or store runs of bytes, each one more than, less than,
+// or the same as the one before.  Then the interpreter restores any
+// bytes the program loaded at Q0 to Q15 (which it uses), and jumps to
+// start.  A table with too few runs to be smaller as seed data is loaded
+// directly, as usual.
+
+// BootstrapReport says what Bootstrap did.
+type BootstrapReport struct {
+	Built      []string // tables built by the bootstrap
+	Loaded     []string // generable tables loaded directly instead
+	TableBytes int      // bytes of the tables built
+	SeedBytes  int      // bytes of seed data for them
+	Bank       uint     // where the interpreter and seed data are
+	Steps      uint64   // steps the bootstrap takes, checked by VerifyBootstrap
+}
+
+// bootstrapNext is the interpreter loop.  The handlers must be
+// in the same page, as it jumps to them by setting only L.
+const bootstrapNext = `boot.next	ldb 0         ; q0-q2: the seed pointer
+	ldh 1
+	ldl 2
+	mv m,a
+	sta 8         ; q8: the handler
+	incw
+	mv m,a
+	sta 9         ; q9: the operand
+	incw
+	stb 0
+	sth 1
+	stl 2
+	setb B(boot.next)
+	seth H(boot.next)
+	lda 8
+	mv a,l
+	seta 1
+	bnz
+`
+
+// bootstrapJump goes back to the interpreter loop.
+const bootstrapJump = `	setb B(boot.next)
+	seth H(boot.next)
+	setl L(boot.next)
+	seta 1
+	bnz
+`
+
+// bootstrapSource is the interpreter and its handlers, in assembler,
+// with restore (restoring Q0 to Q15) before it jumps to start.
+func bootstrapSource(restore string, start uint) string {
+	var sb strings.Builder
+	sb.WriteString(bootstrapNext)
+
+	// The setters put their operand in a quick register:
+	// q6 is the value to store next, and q3-q5 the target pointer.
+	for _, set := range []struct {
+		name string
+		q    int
+	}{{"value", 6}, {"tb", 3}, {"th", 4}, {"tl", 5}} {
+		fmt.Fprintf(&sb, "boot.%s\tlda 9\n\tsta %d\n%s", set.name, set.q, bootstrapJump)
+	}
+
+	// The runs store the value at the target, and step both,
+	// as many times as minus their operand (so 0 means 256),
+	// counting up in q7.
+	for _, run := range []struct{ name, step string }{{"inc", "\tinca\n"}, {"dec", "\tdeca\n"}, {"same", ""}} {
+		fmt.Fprintf(&sb, "boot.%s\tlda 9\n\tsta 7\n", run.name)
+		fmt.Fprintf(&sb, "boot.%s.loop\tldb 3\n\tldh 4\n\tldl 5\n\tlda 6\n\tmv a,m\n%s\tsta 6\n", run.name, run.step)
+		fmt.Fprintf(&sb, "\tincw\n\tstb 3\n\tsth 4\n\tstl 5\n\tlda 7\n\tinca\n\tsta 7\n")
+		fmt.Fprintf(&sb, "\tsetb B(boot.%s.loop)\n\tseth H(boot.%s.loop)\n\tsetl L(boot.%s.loop)\n\tbnz\n%s", run.name, run.name, run.name, bootstrapJump)
+	}
+
+	b, h, l := BhlSplit(start)
+	fmt.Fprintf(&sb, "boot.done\n%s\tsetb $%02x\n\tseth $%02x\n\tsetl $%02x\n\tseta 1\n\tbnz\n", restore, b, h, l)
+	sb.WriteString("\tassert H(boot.done) == H(boot.next)\n")
+	return sb.String()
+}
+
+// generableTables finds the tables marked by GENERABLE,
+// and the addresses they fill.
+func generableTables(mod *Mod) (names []string, starts, sizes []uint) {
+	marked := make(map[string]bool)
+	for _, row := range mod.rows {
+		if row.opcode == "generable" {
+			for _, arg := range row.args {
+				if arg != "" {
+					marked[arg] = true
+				}
+			}
+		}
+	}
+	for _, row := range mod.rows {
+		if !marked[row.label] {
+			continue
+		}
+		delete(marked, row.label)
+		switch {
+		case row.opcode == "bank" && row.args[0] != "":
+			names, starts, sizes = append(names, row.label), append(starts, row.addr<<16), append(sizes, 1<<16)
+		case row.opcode == "row":
+			numRows := uint(1)
+			if len(row.args) >= 2 {
+				numRows = mod.EvalArg(row, 1)
+			}
+			names = append(names, row.label)
+			starts = append(starts, (mod.rowsBank<<16)+(row.addr<<8))
+			sizes = append(sizes, numRows<<8)
+		default:
+			log.Panicf("GENERABLE %q is not a BANK or ROW table, in row: %#v", row.label, row)
+		}
+	}
+	for name := range marked {
+		log.Panicf("GENERABLE %q is not a label", name)
+	}
+	return
+}
+
+// seedRecords encodes the table as runs, writing fcb lines.
+// It returns the number of seed bytes.
+func seedRecords(sb *strings.Builder, start uint, data []byte) int {
+	n := 0
+	record := func(handler string, operand byte) {
+		fmt.Fprintf(sb, "\tfcb L(boot.%s)\n\tfcb $%02x\n", handler, operand)
+		n += 2
+	}
+	b, h, l := BhlSplit(start)
+	record("tb", b)
+	record("th", h)
+	record("tl", l)
+
+	value := -1 // unknown
+	for i := 0; i < len(data); {
+		if value != int(data[i]) {
+			record("value", data[i])
+		}
+		var delta byte
+		if i+1 < len(data) {
+			delta = data[i+1] - data[i]
+		}
+		handler := map[byte]string{1: "inc", 0xFF: "dec"}[delta]
+		if handler == "" {
+			handler, delta = "same", 0
+		}
+		count := 1
+		for count < 256 && i+count < len(data) && data[i+count]-data[i+count-1] == delta {
+			count++
+		}
+		record(handler, byte(-count))
+		i += count
+		value = int(data[i-1] + delta)
+	}
+	return n
+}
+
+// Bootstrap returns a module that loads like mod, but with its
+// generable tables built by a bootstrap, and checks it with VerifyBootstrap.
+func Bootstrap(mod *Mod) (*Mod, *BootstrapReport) {
+	report := &BootstrapReport{}
+	names, starts, sizes := generableTables(mod)
+	mem := make(map[uint]byte)
+	used := make(map[uint]bool) // banks
+	for _, seg := range ModSegments(mod) {
+		for i, b := range seg.Data {
+			mem[seg.Addr+uint(i)] = b
+			used[(seg.Addr+uint(i))>>16] = true
+		}
+	}
+	for report.Bank = 15; report.Bank > 0 && used[report.Bank]; report.Bank-- {
+	}
+	if report.Bank == 0 {
+		log.Panicf("No free bank for the bootstrap")
+	}
+
+	var seed strings.Builder
+	built := make(map[uint]bool) // addresses
+	for i, name := range names {
+		data := make([]byte, sizes[i])
+		for j := range data {
+			data[j] = mem[starts[i]+uint(j)]
+		}
+		var sb strings.Builder
+		n := seedRecords(&sb, starts[i], data)
+		if n >= len(data) {
+			report.Loaded = append(report.Loaded, name)
+			continue
+		}
+		report.SeedBytes += n
+		seed.WriteString(sb.String())
+		report.Built = append(report.Built, name)
+		report.TableBytes += len(data)
+		for j := range data {
+			built[starts[i]+uint(j)] = true
+		}
+	}
+
+	var restore strings.Builder
+	for q := uint(0); q < 16; q++ {
+		if b, ok := mem[q]; ok {
+			fmt.Fprintf(&restore, "\tseta $%02x\n\tsta %d\n", b, q)
+		}
+	}
+	code := bootstrapSource(restore.String(), ModStart(mod))
+	text := fmt.Sprintf("\torg $%06x\n%s\nboot.seed\n%s\tfcb L(boot.done)\n\tfcb 0\nboot.end\n", report.Bank<<16, code, seed.String())
+	lines := strings.Split(text, "\n")
+	wheres := make([]string, len(lines))
+	for i := range lines {
+		wheres[i] = fmt.Sprintf("bootstrap:%d", i+1)
+	}
+	boot := AssembleLines(lines, wheres, nil)
+	if end, _ := boot.LabelAddr("boot.end"); end>>16 != report.Bank {
+		log.Panicf("The bootstrap does not fit in bank $%x", report.Bank)
+	}
+	entry, _ := boot.LabelAddr("boot.next")
+	seedAddr, _ := boot.LabelAddr("boot.seed")
+
+	out := &Mod{
+		rows:   mod.rows,
+		labels: make(map[string]*Label),
+		macros: mod.macros,
+	}
+	for name, lab := range mod.labels {
+		out.labels[name] = lab
+	}
+	for _, pair := range mod.generated {
+		if !built[pair.addr] {
+			out.generated = append(out.generated, pair)
+		}
+	}
+	out.generated = append(out.generated, boot.generated...)
+	// Last, the IPL points the seed pointer at the seed, and jumps to boot.next.
+	sb, sh, sl := BhlSplit(seedAddr)
+	for q, val := range []byte{sb, sh, sl} {
+		out.generated = append(out.generated, AddrData{uint(q), val})
+	}
+	out.labels["start"] = &Label{addr: entry}
+
+	steps, err := VerifyBootstrap(mod, out)
+	if err != nil {
+		log.Panicf("Bootstrap is wrong: %v", err)
+	}
+	report.Steps = steps
+	return out, report
+}
+
+// VerifyBootstrap loads boot by IPL and runs it until it reaches
+// the start of mod, then compares the memory with what mod loads.
+// It returns how many steps that took.
+func VerifyBootstrap(mod, boot *Mod) (uint64, error) {
+	vm := &Vm{Logf: func(string, ...any) {}}
+	vm.IPL(CreateIPL(boot))
+	start := ModStart(mod)
+	const maxSteps = 1 << 30
+	for vm.PC() != start {
+		if !vm.Steps(1) || vm.StepNum() > maxSteps {
+			return vm.StepNum(), fmt.Errorf("bootstrap stopped at $%06x after %d steps", vm.PC(), vm.StepNum())
+		}
+	}
+	for _, seg := range ModSegments(mod) {
+		for i, want := range seg.Data {
+			addr := seg.Addr + uint(i)
+			if got := vm.Peek(addr); got != want {
+				return vm.StepNum(), fmt.Errorf("at $%06x the bootstrap left $%02x, want $%02x", addr, got, want)
+			}
+		}
+	}
+	return vm.StepNum(), nil
+}
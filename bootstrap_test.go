@@ -0,0 +1,39 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	mod := assembleText(fillProgram + `
+	org 2
+	fcb $42         ; the bootstrap must restore Q2
+RowsBank	BANK
+NegRow	ROW (0-_L_)
+Steps	ROW (_L_>>>(_H_+3)), 3
+Noise	ROW ((_L_*_L_)*37)
+Adds	BANK (_H_+_L_)
+	generable NegRow, Steps, Noise, Adds
+`)
+	boot, report := Bootstrap(mod)
+	if got := fmt.Sprint(report.Built, report.Loaded); got != "[NegRow Steps Adds] [Noise]" {
+		t.Errorf("built and loaded %s", got)
+	}
+	if report.TableBytes != 256*4+65536 || report.SeedBytes >= 2000 || report.Bank != 15 {
+		t.Errorf("bad report: %#v", report)
+	}
+
+	// Bootstrap checked it, but check again that it is really smaller,
+	// and that VerifyBootstrap notices a wrong table.
+	if len(CreateIPL(boot)) > len(CreateIPL(mod))/10 {
+		t.Errorf("bootstrap IPL is %d bytes, for %d", len(CreateIPL(boot)), len(CreateIPL(mod)))
+	}
+	if _, err := VerifyBootstrap(mod, boot); err != nil {
+		t.Error(err)
+	}
+	mod.generated = append(mod.generated, AddrData{0x020101, 7}) // Adds[1][1] = 2
+	if _, err := VerifyBootstrap(mod, boot); err == nil || err.Error() != "at $020101 the bootstrap left $02, want $07" {
+		t.Errorf("got error %v", err)
+	}
+}
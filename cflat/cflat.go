@@ -1113,7 +1113,7 @@ func QRepr(x any) string {
 func DeHex(s string) uint {
 	x, err := strconv.ParseUint(s, 16, 64)
 	if err != nil {
-		log.Panicf("Cannot convert hex $q: %v", s, err)
+		log.Panicf("Cannot convert hex %q: %v", s, err)
 	}
 	return uint(x)
 }
@@ -0,0 +1,96 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"log"
+)
+
+// CreateCompactIPL is like CreateIPL, but smaller.  It loads the
+// generated bytes in address order (the later byte wins, if an address
+// was generated twice), each with one MV M,M (during IPL, M reads as
+// the pair's data byte, so that stores it at W, with no SETA),
+// and sets only the bytes of W that change (or uses INCW, if that is
+// no longer).  It checks, with DecompileIPL, that it loads the same
+// RAM and start address as CreateIPL.
+func CreateCompactIPL(mod *Mod) []byte {
+	var ipl []byte
+	// The registers, as the IPL has set them; at first they are unknown.
+	var reg [4]byte // A, B, H, L
+	var known [4]bool
+
+	set := func(r int, val byte) {
+		if !known[r] || reg[r] != val {
+			ipl = append(ipl, Encode(setOps[r]), val)
+			reg[r], known[r] = val, true
+		}
+	}
+	// setW points W at addr, by INCWs from where it is if that is
+	// no longer than setting the bytes that differ.
+	setW := func(addr uint) {
+		b, h, l := BhlSplit(addr)
+		if known[1] && known[2] && known[3] {
+			w := uint(reg[1])<<16 | uint(reg[2])<<8 | uint(reg[3])
+			changed := Truth(reg[1] != b) + Truth(reg[2] != h) + Truth(reg[3] != l)
+			if w < addr && addr-w <= changed {
+				for ; w < addr; w++ {
+					ipl = append(ipl, Encode("incw"), 0)
+				}
+				reg[1], reg[2], reg[3] = b, h, l
+				return
+			}
+		}
+		set(1, b)
+		set(2, h)
+		set(3, l)
+	}
+
+	for _, seg := range ModSegments(mod) {
+		setW(seg.Addr)
+		for i, data := range seg.Data {
+			if i > 0 {
+				ipl = append(ipl, Encode("incw"), 0)
+				reg[1], reg[2], reg[3] = BhlSplit(seg.Addr + uint(i))
+			}
+			ipl = append(ipl, Encode("mv", 4, 4), data)
+		}
+	}
+
+	// Jump to start.
+	if !known[0] || reg[0] == 0 {
+		set(0, 1)
+	}
+	setW(ModStart(mod))
+	ipl = append(ipl, Encode("bnz"), 0)
+
+	if err := CompareIPL(CreateIPL(mod), ipl); err != nil {
+		log.Panicf("Compact IPL is wrong: %v", err)
+	}
+	return ipl
+}
+
+// setOps are the SET instructions, by register number.
+var setOps = []string{"seta", "setb", "seth", "setl"}
+
+// CompareIPL tells if two IPL streams load the same RAM and start address.
+func CompareIPL(want, got []byte) error {
+	w, err := DecompileIPL(want)
+	if err != nil {
+		return err
+	}
+	g, err := DecompileIPL(got)
+	if err != nil {
+		return err
+	}
+	if w.Start != g.Start || w.Started != g.Started {
+		return fmt.Errorf("starts at $%06x (%v), want $%06x (%v)", g.Start, g.Started, w.Start, w.Started)
+	}
+	if len(w.Mem) != len(g.Mem) {
+		return fmt.Errorf("loads %d bytes, want %d", len(g.Mem), len(w.Mem))
+	}
+	for addr, val := range w.Mem {
+		if gv, ok := g.Mem[addr]; !ok || gv != val {
+			return fmt.Errorf("at $%06x loads $%02x (%v), want $%02x", addr, gv, ok, val)
+		}
+	}
+	return nil
+}
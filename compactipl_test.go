@@ -0,0 +1,59 @@
+package ABhL // pronounced "owl"
+
+import (
+	"testing"
+)
+
+func TestCompactIPL(t *testing.T) {
+	mod := assembleText(fillProgram + `
+	org $10000
+	fcb 7
+	fcb 7
+	fcb 7
+	org $10005
+	fcb 7
+	org $100     ; overwrite the first byte of fillProgram
+	overlay
+	fcb 4
+	endoverlay
+`)
+	full, compact := CreateIPL(mod), CreateCompactIPL(mod)
+	if err := CompareIPL(full, compact); err != nil {
+		t.Fatal(err)
+	}
+	if len(compact) >= len(full) {
+		t.Errorf("compact IPL is %d bytes, for %d", len(compact), len(full))
+	}
+
+	// A gap sets only L.
+	m, err := DecompileIPL(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Start != 0x100 || m.Mem[0x10005] != 7 {
+		t.Errorf("bad map: %#v", m)
+	}
+	var seta, incw int
+	for i := 0; i < len(compact); i += 2 {
+		switch compact[i] {
+		case Encode("seta"):
+			seta++
+		case Encode("incw"):
+			incw++
+		}
+	}
+	// The bytes need no SETA, as MV M,M stores the data byte;
+	// only the jump sets A.  There are INCWs between the 25 bytes
+	// of fillProgram, and in bank 1 from $10000 to $10002,
+	// which then sets L to 5.
+	if seta != 1 || incw != 24+2 {
+		t.Errorf("%d SETAs and %d INCWs", seta, incw)
+	}
+
+	// Running it works as well.
+	vm := &Vm{Logf: quiet}
+	vm.IPL(compact)
+	if vm.PC() != 0x100 || vm.Peek(0x10002) != 7 {
+		t.Errorf("pc %x", vm.PC())
+	}
+}
@@ -0,0 +1,40 @@
+package ABhL // pronounced "owl"
+
+// Strobes are the control signals asserted during one clock cycle.
+type Strobes uint16
+
+const (
+	LatchA Strobes = 1 << iota // register A latches the data bus
+	LatchB                     // register B latches the data bus
+	LatchH                     // register H latches the data bus
+	LatchL                     // register L latches the data bus
+	LatchT                     // the T latch takes the opcode (every FETCH)
+	LoadPC                     // the PC loads W (a taken BNZ)
+	ReadM                      // memory drives the data bus
+	WriteM                     // memory is written from the data bus
+	ReadE                      // port E drives the data bus
+	ReadF                      // port F drives the data bus
+	ReadG                      // port G drives the data bus
+	WriteE                     // port E takes the data bus
+	WriteF                     // port F takes the data bus
+	WriteG                     // port G takes the data bus
+)
+
+// StrobeNames names the Strobes, in bit order.
+var StrobeNames = []string{
+	"latch_a", "latch_b", "latch_h", "latch_l", "latch_t", "load_pc",
+	"read_m", "write_m", "read_e", "read_f", "read_g", "write_e", "write_f", "write_g",
+}
+
+var readStrobes = []Strobes{0, 0, 0, 0, ReadM, ReadE, ReadF, ReadG}
+var writeStrobes = []Strobes{LatchA, LatchB, LatchH, LatchL, WriteM, WriteE, WriteF, WriteG}
+
+// Cycle is what happened on the buses during one clock cycle.
+type Cycle struct {
+	Num     uint64  // counting from 0, like Vm.Cycles
+	Execute bool    // an EXECUTE cycle, else a FETCH cycle
+	IPL     bool    // fed by IPL, instead of fetched from memory
+	Addr    uint    // the 24-bit address bus
+	Data    byte    // the data bus
+	Strobes Strobes // control signals asserted
+}
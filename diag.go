@@ -0,0 +1,214 @@
+package ABhL // pronounced "owl"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity says how bad a Diagnostic is.
+type Severity int
+
+const (
+	SevError Severity = iota
+	SevWarning
+)
+
+func (s Severity) String() string {
+	if s == SevWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic is an error or warning from the assembler,
+// at a source location.  Line and Column count from 1,
+// and are 0 if not known.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Message  string
+}
+
+// String formats the Diagnostic like a compiler does:
+//
+//	hello.owl:12:7: error: unknown label "nowhere"
+func (d Diagnostic) String() string {
+	switch {
+	case d.File == "":
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Message)
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// FileDiagnostic is the error for a file that cannot be read.
+func FileDiagnostic(filename string, err error) Diagnostic {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err // the filename is already in the Diagnostic
+	}
+	return Diagnostic{Severity: SevError, File: filename, Message: fmt.Sprintf("cannot read file: %v", err)}
+}
+
+// Diagnostics collects the Diagnostics of an assembly.
+// After an error, the assembler goes on to the next row, and finishes
+// the pass it is in, but does not start another.
+type Diagnostics struct {
+	List      []Diagnostic
+	MaxErrors int  // stop after this many errors, if not 0
+	Stopped   bool // stopped at MaxErrors
+}
+
+// Errors counts the errors (not the warnings).
+func (ds *Diagnostics) Errors() int {
+	n := 0
+	for _, d := range ds.List {
+		if d.Severity == SevError {
+			n++
+		}
+	}
+	return n
+}
+
+// Write writes the Diagnostics, one per line, sorted by file and line.
+// Diagnostics at the same line stay in the order they were collected.
+func (ds *Diagnostics) Write(w io.Writer) {
+	sorted := append([]Diagnostic(nil), ds.List...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		return sorted[i].Line < sorted[j].Line
+	})
+	for _, d := range sorted {
+		fmt.Fprintf(w, "%s\n", d)
+	}
+	if ds.Stopped {
+		fmt.Fprintf(w, "too many errors (%d), stopping\n", ds.MaxErrors)
+	}
+}
+
+// String is what Write writes.
+func (ds *Diagnostics) String() string {
+	var sb strings.Builder
+	ds.Write(&sb)
+	return sb.String()
+}
+
+// rowFailed is the panic after an error in a row,
+// recovered by tryRow so the pass goes on to the next row.
+type rowFailed struct{}
+
+// tooManyErrors is the panic after MaxErrors errors,
+// recovered by runPasses.
+type tooManyErrors struct{}
+
+// diagnostic makes a Diagnostic at the row, with the column
+// where near is in the source line (or else its opcode).
+func diagnostic(sev Severity, row *Row, near string, format string, args ...any) Diagnostic {
+	d := Diagnostic{Severity: sev, Message: fmt.Sprintf(format, args...)}
+	if row == nil {
+		return d
+	}
+	d.File = row.where
+	if i := strings.LastIndexByte(row.where, ':'); i > 0 {
+		if n, err := strconv.Atoi(row.where[i+1:]); err == nil {
+			d.File, d.Line = row.where[:i], n
+		}
+	}
+	d.Column = row.column(near)
+	return d
+}
+
+// column finds near in the source line of the row, after the label
+// (unless near is the label).  Failing that, it finds the opcode.
+func (row *Row) column(near string) int {
+	if row.line == "" {
+		return 0
+	}
+	skip := 0
+	if row.label != "" && near != row.label && strings.HasPrefix(row.line, row.label) {
+		skip = len(row.label)
+	}
+	if near != "" {
+		if i := strings.Index(row.line[skip:], near); i >= 0 {
+			return skip + i + 1
+		}
+	}
+	if row.opcode != "" {
+		if i := strings.Index(strings.ToLower(row.line[skip:]), row.opcode); i >= 0 {
+			return skip + i + 1
+		}
+	}
+	return 1
+}
+
+// report records the Diagnostic, and goes on.
+// Without Diagnostics (as when linking), an error panics.
+func (mod *Mod) report(d Diagnostic) {
+	if mod.diags == nil {
+		if d.Severity == SevError {
+			log.Panicf("%s", d)
+		}
+		return
+	}
+	mod.diags.List = append(mod.diags.List, d)
+	if d.Severity == SevError && mod.diags.MaxErrors > 0 && mod.diags.Errors() >= mod.diags.MaxErrors {
+		mod.diags.Stopped = true
+		panic(tooManyErrors{})
+	}
+}
+
+// errorf reports an error in the row, and abandons the row.
+func (mod *Mod) errorf(row *Row, near string, format string, args ...any) {
+	mod.report(diagnostic(SevError, row, near, format, args...))
+	panic(rowFailed{})
+}
+
+// warnf reports a warning in the row, and goes on.
+func (mod *Mod) warnf(row *Row, near string, format string, args ...any) {
+	mod.report(diagnostic(SevWarning, row, near, format, args...))
+}
+
+// tryRow runs fn for a row, recovering if errorf abandons the row.
+func tryRow(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(rowFailed); !ok {
+				panic(r)
+			}
+		}
+	}()
+	fn()
+}
+
+// runPasses runs the passes in order, but none after one with errors.
+// It reports whether there were no errors.
+func (mod *Mod) runPasses(passes ...func(*Mod)) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, stop := r.(tooManyErrors); !stop {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	for _, pass := range passes {
+		pass(mod)
+		if mod.diags.Errors() > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// diagnose assembles the text, returning its Diagnostics.
+func diagnose(text string, maxErrors int) *Diagnostics {
+	lines, wheres := sourceLines("bad.owl", text)
+	diags := &Diagnostics{MaxErrors: maxErrors}
+	Assemble(lines, wheres, nil, diags)
+	return diags
+}
+
+func TestDiagnosticString(t *testing.T) {
+	for _, it := range []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{SevError, "a.owl", 12, 7, "oops"}, "a.owl:12:7: error: oops"},
+		{Diagnostic{SevWarning, "a.owl", 12, 0, "hmm"}, "a.owl:12: warning: hmm"},
+		{Diagnostic{SevError, "bootstrap", 0, 0, "oops"}, "bootstrap: error: oops"},
+		{Diagnostic{SevError, "", 0, 0, "oops"}, "error: oops"},
+	} {
+		if got := it.d.String(); got != it.want {
+			t.Errorf("got %q, want %q", got, it.want)
+		}
+	}
+}
+
+// TestDiagnosticsContinue checks that the assembler goes on
+// to report errors in later rows of the same pass.
+func TestDiagnosticsContinue(t *testing.T) {
+	diags := diagnose(`start	seta 1
+	setl L(nowhere)
+	mv a,z
+	org $100
+	fcb 1+
+x	equ 1
+x	equ 2
+	fcb B(1:2)
+	assert 1 == 2
+	fcw x`, 0)
+	want := `bad.owl:2:9: error: unknown label "nowhere"
+bad.owl:3:7: error: unknown register "z" in argument 2
+bad.owl:5:6: error: cannot parse expression "1+": unexpected end of expression
+bad.owl:7:1: warning: label "x" redefined (the first is at bad.owl:6); the last one wins
+bad.owl:8:6: error: function B takes 1 arguments (separated by colons), not 2
+bad.owl:9:9: error: assertion "1 == 2" fails
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if diags.Errors() != 5 {
+		t.Errorf("got %d errors", diags.Errors())
+	}
+}
+
+// TestDiagnosticsSorted checks that Write sorts by file and line,
+// keeping the collected order within a line.
+func TestDiagnosticsSorted(t *testing.T) {
+	diags := &Diagnostics{List: []Diagnostic{
+		{SevError, "b.owl", 2, 1, "four"},
+		{SevWarning, "a.owl", 9, 1, "two"},
+		{SevError, "b.owl", 1, 5, "three"},
+		{SevError, "a.owl", 3, 7, "one"},
+		{SevError, "b.owl", 2, 9, "five"},
+	}}
+	want := `a.owl:3:7: error: one
+a.owl:9:1: warning: two
+b.owl:1:5: error: three
+b.owl:2:1: error: four
+b.owl:2:9: error: five
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if diags.List[0].Message != "four" {
+		t.Errorf("Write reordered the List")
+	}
+}
+
+func TestReadSourcesMissing(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.owl")
+	if err := os.WriteFile(good, []byte("\tfcb 1\n\tfcb 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.owl")
+	diags := &Diagnostics{}
+	lines, wheres := ReadSources([]string{missing, good}, diags)
+	if len(lines) != 2 || wheres[1] != good+":2" {
+		t.Errorf("got %q %q", lines, wheres)
+	}
+	if got, want := diags.String(), missing+": error: cannot read file: no such file or directory\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestDiagnosticsStopAfterPass checks that errors in one pass
+// stop the assembler before the next.
+func TestDiagnosticsStopAfterPass(t *testing.T) {
+	diags := diagnose("\tldq 1\n\tfcb nowhere\nnoname\tequ 1\n\tfoo\n", 0)
+	want := "bad.owl:1:2: error: unknown opcode \"ldq\"\nbad.owl:4:2: error: unknown opcode \"foo\"\n"
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiagnosticsMaxErrors(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&text, "\tfcb nowhere%d\n", i)
+	}
+	diags := diagnose(text.String(), 3)
+	if diags.Errors() != 3 || !diags.Stopped {
+		t.Errorf("got %d errors, stopped %v", diags.Errors(), diags.Stopped)
+	}
+	if got := diags.String(); !strings.HasSuffix(got, "bad.owl:3:6: error: unknown label \"nowhere2\"\ntoo many errors (3), stopping\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestDiagnosticsMacros(t *testing.T) {
+	diags := diagnose("m\tmacro\n\tfcb 1\n\tendmacro\nM\tmacro\n\tfcb 2\n\tendmacro\n\tmacro\n\tendmacro\n", 0)
+	want := `bad.owl:4:1: warning: MACRO M redefined (the first is at bad.owl:1); the last one wins
+bad.owl:7:2: error: MACRO needs a label, to name it
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestAssembleLinesPanics checks that AssembleLines
+// panics with compiler-style errors.
+func TestAssembleLinesPanics(t *testing.T) {
+	msg := assemblePanic("\tseta nowhere\n")
+	if msg != "text:17:7: error: unknown label \"nowhere\"\n" {
+		t.Errorf("got %q", msg)
+	}
+}
+
+func TestObjectDiagnostics(t *testing.T) {
+	lines, wheres := sourceLines("bad.owl", "\torg nowhere\n\trmb 1:\n")
+	diags := &Diagnostics{}
+	if obj := AssembleToObject(lines, wheres, nil, diags); obj != nil {
+		t.Errorf("got an object, with errors")
+	}
+	want := `bad.owl:1:6: error: ORG cannot use relocatable or imported labels
+bad.owl:2:7: error: cannot parse expression "1:": unparsed remainder is ":"
+`
+	if got := diags.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
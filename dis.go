@@ -0,0 +1,138 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// regLetters are the register operands, as the assembler spells them.
+var regLetters = "abhlmefg"
+
+// Disasm returns the assembler text for the instruction with opcode t,
+// and its size in bytes: 2 for the SET instructions (imm is their
+// immediate byte), otherwise 1.  Undefined opcodes become "fcb $xx".
+func Disasm(t, imm byte) (text string, size int) {
+	op := Opcodes[t]
+	if op == nil {
+		return fmt.Sprintf("fcb $%02x", t), 1
+	}
+	mnemonic := op.Name(t)
+	switch op.Args {
+	case "reg,reg":
+		return fmt.Sprintf("%s %c,%c", mnemonic, regLetters[op.Field(t, 'f')], regLetters[op.Field(t, 't')]), op.Size
+	case "quick":
+		return fmt.Sprintf("%s q%d", mnemonic, op.Field(t, 'q')), op.Size
+	case "byte":
+		return fmt.Sprintf("%s $%02x", mnemonic, imm), op.Size
+	}
+	return mnemonic, op.Size
+}
+
+// Memory is what Disassemble reads: a *Vm, or an *Image.
+type Memory interface {
+	Peek(addr uint) byte
+}
+
+// Image is a raw memory image, loaded at Base.
+type Image struct {
+	Base  uint
+	Bytes []byte
+}
+
+// Peek returns the byte at addr, or 0 outside the image.
+func (im *Image) Peek(addr uint) byte {
+	if addr < im.Base || addr-im.Base >= uint(len(im.Bytes)) {
+		return 0
+	}
+	return im.Bytes[addr-im.Base]
+}
+
+// Region is the addresses from Start up to (not including) End.
+type Region struct {
+	Start, End uint
+}
+
+// Instruction is one disassembled instruction.
+type Instruction struct {
+	Addr  uint
+	Bytes []byte
+	Text  string // in the assembler's syntax, like "lda q3" or "mv m,a"
+}
+
+// Disassemble decodes the instruction at addr in mem.
+func Disassemble(mem Memory, addr uint) Instruction {
+	t, imm := mem.Peek(addr), mem.Peek(addr+1)
+	text, size := Disasm(t, imm)
+	return Instruction{Addr: addr, Bytes: []byte{t, imm}[:size], Text: text}
+}
+
+// WriteDisassembly writes the region of mem as assembler source,
+// labeled with the "addr" symbols in syms (which may be nil),
+// and with each address and its bytes in a comment.
+// A SET at the end may take its immediate byte from past the end.
+func WriteDisassembly(w io.Writer, mem Memory, region Region, syms Symbols) {
+	labels := make(map[uint][]string)
+	for _, sym := range syms {
+		if sym.Kind == "addr" && region.Start <= sym.Addr && sym.Addr < region.End {
+			labels[sym.Addr] = append(labels[sym.Addr], sym.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "\torg $%06x\n", region.Start)
+	for addr := region.Start; addr < region.End; {
+		in := Disassemble(mem, addr)
+		if len(in.Bytes) == 2 && labels[addr+1] != nil {
+			// A label inside a two-byte instruction means these are data.
+			in = Instruction{Addr: addr, Bytes: in.Bytes[:1], Text: fmt.Sprintf("fcb $%02x", in.Bytes[0])}
+		}
+		names := labels[addr]
+		for len(names) > 1 {
+			fmt.Fprintf(w, "%s\n", names[0])
+			names = names[1:]
+		}
+		label := ""
+		if len(names) == 1 {
+			label = names[0]
+		}
+		fmt.Fprintf(w, "%-12s %-16s ; %06x: % 02x\n", label, in.Text, addr, in.Bytes)
+		addr += uint(len(in.Bytes))
+	}
+}
+
+// IPLRegions runs the IPL in a new Vm, returning the Vm (with
+// its PC at the start address) and the regions of memory loaded.
+func IPLRegions(ipl []byte) (vm *Vm, regions []Region) {
+	vm = &Vm{Logf: func(string, ...any) {}}
+	var loaded []uint
+	vm.OnCycle = func(c *Cycle) {
+		if c.IPL && c.Strobes&WriteM != 0 {
+			loaded = append(loaded, c.Addr)
+		}
+	}
+	vm.IPL(ipl)
+	vm.OnCycle = nil
+	return vm, Regions(loaded)
+}
+
+// Regions merges the addresses into sorted contiguous regions.
+func Regions(addrs []uint) (regions []Region) {
+	addrs = append([]uint(nil), addrs...)
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		n := len(regions)
+		if n > 0 && addr <= regions[n-1].End {
+			if addr == regions[n-1].End {
+				regions[n-1].End++
+			}
+			continue
+		}
+		regions = append(regions, Region{addr, addr + 1})
+	}
+	return
+}
+
+// String is like "$000100-$00012f" (inclusive).
+func (r Region) String() string {
+	return fmt.Sprintf("$%06x-$%06x", r.Start, r.End-1)
+}
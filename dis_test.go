@@ -0,0 +1,83 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDisasm(t *testing.T) {
+	for _, it := range []struct {
+		t, imm byte
+		want   string
+		size   int
+	}{
+		{0x04, 0x12, "seta $12", 2},
+		{0x07, 0xFF, "setl $ff", 2},
+		{0x0B, 0, "decw", 1},
+		{0x0C, 0, "bnz", 1},
+		{0x0D, 0, "fcb $0d", 1},
+		{0x00, 0, "stop", 1},
+		{0x3F, 0, "fcb $3f", 1},
+		{0x70, 0, "mv f,a", 1},
+		{0x47, 0, "mv a,g", 1},
+		{0x9F, 0, "ldb q15", 1},
+		{0xF3, 0, "stl q3", 1},
+	} {
+		got, size := Disasm(it.t, it.imm)
+		if got != it.want || size != it.size {
+			t.Errorf("Disasm(%02x, %02x) = %q, %d; want %q, %d", it.t, it.imm, got, size, it.want, it.size)
+		}
+	}
+}
+
+// quickEqus defines q0 to q15, as lib1.owl does.
+func quickEqus() (lines []string) {
+	for q := 0; q < 16; q++ {
+		lines = append(lines, fmt.Sprintf("q%d\tequ %d", q, q))
+	}
+	return
+}
+
+func assembleText(text string) *Mod {
+	lines := append(quickEqus(), strings.Split(text, "\n")...)
+	wheres := make([]string, len(lines))
+	for i := range lines {
+		wheres[i] = fmt.Sprintf("text:%d", i+1)
+	}
+	return AssembleLines(lines, wheres, nil)
+}
+
+// TestDisassembleIPL disassembles what an IPL loads,
+// and checks that it assembles back to the same bytes.
+func TestDisassembleIPL(t *testing.T) {
+	mod := assembleText(fillProgram + "\n\torg $200\ndata\tfcb 1\n\tfcb 2\n\tfcb 3\n")
+	vm, regions := IPLRegions(CreateIPL(mod))
+	if vm.PC() != 0x100 {
+		t.Errorf("start is %x, want 100", vm.PC())
+	}
+	if len(regions) != 2 || regions[0].String() != "$000100-$000118" || regions[1].String() != "$000200-$000202" {
+		t.Fatalf("regions are %v", regions)
+	}
+
+	var sb strings.Builder
+	for _, r := range regions {
+		WriteDisassembly(&sb, vm, r, ModSymbols(mod))
+	}
+	text := sb.String()
+	for _, want := range []string{
+		"start        seta $05         ; 000100: 04 05\n",
+		"loop         ldb q0           ; 000108: 90\n",
+		"             mv a,m           ; 00010b: 44\n",
+		"data         fcb $01          ; 000200: 01\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+
+	again := assembleText(text)
+	if fmt.Sprint(again.generated) != fmt.Sprint(mod.generated) {
+		t.Errorf("disassembly assembles to different bytes:\n%s", text)
+	}
+}
@@ -0,0 +1,342 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment is contiguous bytes to load at Addr.
+type Segment struct {
+	Addr uint
+	Data []byte
+}
+
+// ImageFormats are the formats of WriteImage and ReadImage.
+// "ipl" and "compact" (see CreateCompactIPL) are only written;
+// they load by IPL.
+var ImageFormats = []string{"ipl", "compact", "ihex", "srec", "bin"}
+
+// ModSegments returns the bytes generated by the module,
+// as contiguous segments in address order.
+// If an address was generated twice, the later byte wins.
+func ModSegments(mod *Mod) []Segment {
+	mem := make(map[uint]byte)
+	var addrs []uint
+	for _, pair := range mod.generated {
+		if _, ok := mem[pair.addr]; !ok {
+			addrs = append(addrs, pair.addr)
+		}
+		mem[pair.addr] = pair.data
+	}
+	return segmentsOf(mem, addrs)
+}
+
+func segmentsOf(mem map[uint]byte, addrs []uint) (segs []Segment) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		n := len(segs)
+		if n > 0 && segs[n-1].Addr+uint(len(segs[n-1].Data)) == addr {
+			segs[n-1].Data = append(segs[n-1].Data, mem[addr])
+		} else {
+			segs = append(segs, Segment{addr, []byte{mem[addr]}})
+		}
+	}
+	return
+}
+
+// ModStart is the address of the label "start", or 0.
+func ModStart(mod *Mod) uint {
+	if start_label, ok := mod.labels["start"]; ok {
+		return start_label.addr
+	}
+	return 0
+}
+
+// WriteImage writes the module to the file in the format:
+// "ipl" (as WriteIPL), "compact", "ihex", "srec", or "bin".
+// It returns the size of the file.
+func WriteImage(mod *Mod, filename string, format string) int {
+	var bb []byte
+	segs := ModSegments(mod)
+	switch format {
+	case "ipl":
+		bb = CreateIPL(mod)
+	case "compact":
+		bb = CreateCompactIPL(mod)
+	case "ihex":
+		bb = IHex(segs, ModStart(mod))
+	case "srec":
+		bb = SRec(segs, ModStart(mod))
+	case "bin":
+		bb = Bin(segs)
+	default:
+		log.Panicf("Unknown image format %q: use one of %v", format, ImageFormats)
+	}
+	err := ioutil.WriteFile(filename, bb, 0644)
+	if err != nil {
+		log.Panicf("Error writing %s file %q: %v", format, filename, err)
+	}
+	return len(bb)
+}
+
+// Bin is a raw image of the segments, from the lowest address
+// to the highest, with zeros in any gaps.
+// It does not say where to load it, or where to start.
+func Bin(segs []Segment) []byte {
+	if len(segs) == 0 {
+		return nil
+	}
+	last := segs[len(segs)-1]
+	bb := make([]byte, last.Addr+uint(len(last.Data))-segs[0].Addr)
+	for _, seg := range segs {
+		copy(bb[seg.Addr-segs[0].Addr:], seg.Data)
+	}
+	return bb
+}
+
+// IHex is the segments in Intel HEX, with an Extended Linear Address
+// record for each 64K bank, and a Start Linear Address record.
+func IHex(segs []Segment, start uint) []byte {
+	var buf bytes.Buffer
+	record := func(addr uint, typ byte, data []byte) {
+		rec := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		fmt.Fprintf(&buf, ":%X\r\n", append(rec, -sum))
+	}
+
+	bank := ^uint(0)
+	for _, seg := range segs {
+		for i := 0; i < len(seg.Data); {
+			addr := seg.Addr + uint(i)
+			if addr>>16 != bank {
+				bank = addr >> 16
+				record(0, 0x04, []byte{byte(bank >> 8), byte(bank)})
+			}
+			n := 16 - int(addr&15) // align to 16, and so never cross a bank
+			if n > len(seg.Data)-i {
+				n = len(seg.Data) - i
+			}
+			record(addr&0xFFFF, 0x00, seg.Data[i:i+n])
+			i += n
+		}
+	}
+	record(0, 0x05, []byte{byte(start >> 24), byte(start >> 16), byte(start >> 8), byte(start)})
+	record(0, 0x01, nil)
+	return buf.Bytes()
+}
+
+// SRec is the segments in Motorola S-records, with 24-bit S2 data
+// records and an S8 record for the start address.
+func SRec(segs []Segment, start uint) []byte {
+	var buf bytes.Buffer
+	record := func(typ byte, addr uint, alen int, data []byte) {
+		var rec []byte
+		rec = append(rec, byte(alen+len(data)+1))
+		for i := alen - 1; i >= 0; i-- {
+			rec = append(rec, byte(addr>>(8*i)))
+		}
+		rec = append(rec, data...)
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		fmt.Fprintf(&buf, "S%c%X\n", typ, append(rec, ^sum))
+	}
+
+	record('0', 0, 2, []byte("ABhL"))
+	count := 0
+	for _, seg := range segs {
+		for i := 0; i < len(seg.Data); i += 16 {
+			n := 16
+			if n > len(seg.Data)-i {
+				n = len(seg.Data) - i
+			}
+			record('2', seg.Addr+uint(i), 3, seg.Data[i:i+n])
+			count++
+		}
+	}
+	if count < 0x10000 {
+		record('5', uint(count), 2, nil)
+	}
+	record('8', start, 3, nil)
+	return buf.Bytes()
+}
+
+// FormatOf guesses the format of an image file from its name:
+// "ihex" for .hex or .ihex, "srec" for .srec, .s28, or .mot,
+// "ipl" for .ipl, and otherwise "bin".
+func FormatOf(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".hex", ".ihex":
+		return "ihex"
+	case ".srec", ".s28", ".mot":
+		return "srec"
+	case ".ipl":
+		return "ipl"
+	}
+	return "bin"
+}
+
+// ReadImage parses an image in the format "ihex", "srec", or "bin".
+// A bin image loads at org.  hasStart tells if the image gave
+// a start address.
+func ReadImage(bb []byte, format string, org uint) (segs []Segment, start uint, hasStart bool, err error) {
+	switch format {
+	case "bin":
+		return []Segment{{org, bb}}, 0, false, nil
+	case "ihex":
+		return readIHex(bb)
+	case "srec":
+		return readSRec(bb)
+	}
+	return nil, 0, false, fmt.Errorf("cannot load format %q: use ihex, srec, or bin", format)
+}
+
+// hexRecord decodes the hex digits of a record and checks its length
+// byte, and its checksum, which is right if the bytes sum to want.
+func hexRecord(digits string, want byte) ([]byte, error) {
+	if len(digits)%2 != 0 || len(digits) < 4 {
+		return nil, fmt.Errorf("bad length")
+	}
+	rec := make([]byte, len(digits)/2)
+	var sum byte
+	for i := range rec {
+		b, err := strconv.ParseUint(digits[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad hex %q", digits[2*i:2*i+2])
+		}
+		rec[i] = byte(b)
+		sum += rec[i]
+	}
+	if sum != want {
+		return nil, fmt.Errorf("bad checksum")
+	}
+	return rec, nil
+}
+
+// loader collects loaded bytes into segments.
+type loader struct {
+	mem   map[uint]byte
+	addrs []uint
+}
+
+func (ld *loader) load(addr uint, data []byte) {
+	if ld.mem == nil {
+		ld.mem = make(map[uint]byte)
+	}
+	for i, b := range data {
+		a := addr + uint(i)
+		if _, ok := ld.mem[a]; !ok {
+			ld.addrs = append(ld.addrs, a)
+		}
+		ld.mem[a] = b
+	}
+}
+
+func readIHex(bb []byte) (segs []Segment, start uint, hasStart bool, err error) {
+	var ld loader
+	var base uint
+	scanner := bufio.NewScanner(bytes.NewReader(bb))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return nil, 0, false, fmt.Errorf("line %d: does not start with ':'", lineNum)
+		}
+		rec, err := hexRecord(line[1:], 0)
+		if err == nil && len(rec) != int(rec[0])+5 {
+			err = fmt.Errorf("bad length")
+		}
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		addr, data := uint(rec[1])<<8|uint(rec[2]), rec[4:len(rec)-1]
+		if size, ok := map[byte]int{0x01: 0, 0x02: 2, 0x04: 2, 0x05: 4}[rec[3]]; ok && len(data) != size {
+			return nil, 0, false, fmt.Errorf("line %d: bad length for record type %02x", lineNum, rec[3])
+		}
+		switch rec[3] {
+		case 0x00:
+			ld.load(base+addr, data)
+		case 0x01:
+			return segmentsOf(ld.mem, ld.addrs), start, hasStart, nil
+		case 0x02:
+			base = (uint(data[0])<<8 | uint(data[1])) << 4
+		case 0x04:
+			base = (uint(data[0])<<8 | uint(data[1])) << 16
+		case 0x05:
+			start, hasStart = uint(data[0])<<24|uint(data[1])<<16|uint(data[2])<<8|uint(data[3]), true
+		default:
+			return nil, 0, false, fmt.Errorf("line %d: unknown record type %02x", lineNum, rec[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, false, err
+	}
+	return nil, 0, false, fmt.Errorf("missing end of file record")
+}
+
+func readSRec(bb []byte) (segs []Segment, start uint, hasStart bool, err error) {
+	var ld loader
+	scanner := bufio.NewScanner(bytes.NewReader(bb))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] != 'S' {
+			return nil, 0, false, fmt.Errorf("line %d: does not start with 'S'", lineNum)
+		}
+		rec, err := hexRecord(line[2:], 0xFF)
+		if err == nil && len(rec) != int(rec[0])+1 {
+			err = fmt.Errorf("bad length")
+		}
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		alen := map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}[line[1]]
+		if alen == 0 || len(rec) < alen+2 {
+			return nil, 0, false, fmt.Errorf("line %d: bad record type S%c", lineNum, line[1])
+		}
+		var addr uint
+		for _, b := range rec[1 : 1+alen] {
+			addr = addr<<8 | uint(b)
+		}
+		switch line[1] {
+		case '1', '2', '3':
+			ld.load(addr, rec[1+alen:len(rec)-1])
+		case '7', '8', '9':
+			start, hasStart = addr, true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, false, err
+	}
+	return segmentsOf(ld.mem, ld.addrs), start, hasStart, nil
+}
+
+// Load pokes the segments into RAM and sets the PC to start,
+// instead of an IPL.
+func (vm *Vm) Load(segs []Segment, start uint) {
+	for _, seg := range segs {
+		for i, b := range seg.Data {
+			vm.write(seg.Addr+uint(i), b)
+		}
+	}
+	vm.pc = start
+}
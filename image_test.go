@@ -0,0 +1,92 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImageFormats(t *testing.T) {
+	// Two segments, the second crossing from bank 1 to bank 2.
+	mod := assembleText(fillProgram + "\n\torg $1fffe\n\tfcb 1\n\tfcb 2\n\tfcb 3\n")
+	segs := ModSegments(mod)
+	if got := fmt.Sprintf("%x", segs); got != "[{100 0405c00400c1c27890a1b2440ad0e1f2080500060107080c47} {1fffe 010203}]" {
+		t.Fatalf("segments are %s", got)
+	}
+
+	ihex := string(IHex(segs, 0x100))
+	for _, want := range []string{
+		":020000040000FA\r\n",
+		":020000040002F8\r\n",
+		":0400000500000100F6\r\n",
+		":00000001FF\r\n",
+	} {
+		if !strings.Contains(ihex, want) {
+			t.Errorf("missing %q in:\n%s", want, ihex)
+		}
+	}
+	srec := string(SRec(segs, 0x100))
+	if !strings.Contains(srec, "S20701FFFE010203F4\n") || !strings.HasSuffix(srec, "S804000100FA\n") {
+		t.Errorf("bad S-records:\n%s", srec)
+	}
+
+	for _, format := range []string{"ihex", "srec", "bin"} {
+		var bb []byte
+		switch format {
+		case "ihex":
+			bb = []byte(ihex)
+		case "srec":
+			bb = []byte(srec)
+		case "bin":
+			bb = Bin(segs)
+		}
+		got, start, hasStart, err := ReadImage(bb, format, 0x100)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if format == "bin" {
+			if len(got) != 1 || len(got[0].Data) != 0x1ff01 || got[0].Data[0x1fefe] != 1 {
+				t.Errorf("bin: bad image")
+			}
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(segs) || start != 0x100 || !hasStart {
+			t.Errorf("%s: read %x, start %x", format, got, start)
+		}
+	}
+
+	// Loading gives the same RAM and PC as IPL.
+	vmIPL := &Vm{Logf: quiet}
+	vmIPL.IPL(CreateIPL(mod))
+	vmLoad := &Vm{Logf: quiet}
+	vmLoad.Load(segs, ModStart(mod))
+	if vmLoad.PC() != vmIPL.PC() {
+		t.Errorf("PC is %x, want %x", vmLoad.PC(), vmIPL.PC())
+	}
+	for _, seg := range segs {
+		for i := range seg.Data {
+			addr := seg.Addr + uint(i)
+			if vmLoad.Peek(addr) != vmIPL.Peek(addr) {
+				t.Errorf("at %x: %02x, want %02x", addr, vmLoad.Peek(addr), vmIPL.Peek(addr))
+			}
+		}
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	for _, it := range []struct{ format, text, want string }{
+		{"ihex", ":0A01000070460500060107000C47D8\n:00000001FF\n", "line 1: bad checksum"},
+		{"ihex", ":02010000708D\n", "line 1: bad length"},
+		{"ihex", ":0100000070\n", "line 1: bad checksum"},
+		{"ihex", ":0100000070" + "8F\n", "missing end of file record"},
+		{"srec", "S20E00010070460500060107000C47D5\n", "line 1: bad checksum"},
+		{"srec", "X1\n", "line 1: does not start with 'S'"},
+		{"elf", "", `cannot load format "elf": use ihex, srec, or bin`},
+	} {
+		_, _, _, err := ReadImage([]byte(it.text), it.format, 0)
+		if err == nil || err.Error() != it.want {
+			t.Errorf("%s %q: got error %v, want %q", it.format, it.text, err, it.want)
+		}
+	}
+}
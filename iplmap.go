@@ -0,0 +1,137 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// IPLMap is what an IPL stream loads, recovered by DecompileIPL.
+type IPLMap struct {
+	Mem     map[uint]byte // the bytes loaded, by address
+	Regions []Region      // the addresses loaded, contiguous and sorted
+	Start   uint          // where the last taken BNZ jumps
+	Started bool          // whether any BNZ was taken
+	Pairs   int           // number of (opcode, data) pairs
+}
+
+// DecompileIPL interprets an IPL stream -- pairs of an opcode and
+// its data, as CreateIPL makes -- without running it in a Vm,
+// to recover the memory it loads and its start address.
+// It follows the registers and memory (but M, as a source,
+// is the data byte of the pair, as in Vm.IPL), not the ports:
+// an IPL that uses a port, or an undefined opcode, is an error.
+func DecompileIPL(ipl []byte) (*IPLMap, error) {
+	if len(ipl)%2 != 0 {
+		return nil, fmt.Errorf("IPL has an odd length: %d", len(ipl))
+	}
+	m := &IPLMap{Mem: make(map[uint]byte), Pairs: len(ipl) / 2}
+	var reg [4]byte // A, B, H, L
+	w := func() uint { return uint(reg[1])<<16 | uint(reg[2])<<8 | uint(reg[3]) }
+	get := func(r uint) byte {
+		if r == 4 {
+			return m.Mem[w()]
+		}
+		return reg[r]
+	}
+	put := func(r uint, val byte) {
+		if r == 4 {
+			m.Mem[w()] = val
+		} else {
+			reg[r] = val
+		}
+	}
+
+	for i := 0; i < len(ipl); i += 2 {
+		t, data := ipl[i], ipl[i+1]
+		op := Opcodes[t]
+		if op == nil || op.Mnemonic == "stop" {
+			return nil, fmt.Errorf("pair %d (offset $%x): undefined opcode $%02x", i/2, i, t)
+		}
+		switch op.Mnemonic {
+		case "mv":
+			from, to := op.Field(t, 'f'), op.Field(t, 't')
+			if from > 4 || to > 4 {
+				text, _ := Disasm(t, data)
+				return nil, fmt.Errorf("pair %d (offset $%x): %q uses a port", i/2, i, text)
+			}
+			val := get(from)
+			if from == 4 {
+				val = data // during IPL, M reads as the data byte, not RAM
+			}
+			put(to, val)
+		case "ld":
+			reg[op.Field(t, 'r')] = m.Mem[op.Field(t, 'q')]
+		case "st":
+			m.Mem[op.Field(t, 'q')] = reg[op.Field(t, 'r')]
+		case "set":
+			reg[op.Field(t, 'r')] = data
+		case "inca":
+			reg[0]++
+		case "deca":
+			reg[0]--
+		case "incw":
+			reg[1], reg[2], reg[3] = BhlSplit(w() + 1)
+		case "decw":
+			reg[1], reg[2], reg[3] = BhlSplit(w() - 1)
+		case "bnz":
+			if reg[0] != 0 {
+				m.Start, m.Started = w(), true
+			}
+		default:
+			panic("DecompileIPL does not know " + op.Mnemonic)
+		}
+	}
+
+	addrs := make([]uint, 0, len(m.Mem))
+	for addr := range m.Mem {
+		addrs = append(addrs, addr)
+	}
+	m.Regions = Regions(addrs)
+	return m, nil
+}
+
+// Peek returns the byte loaded at addr, or 0.
+func (m *IPLMap) Peek(addr uint) byte {
+	return m.Mem[addr]
+}
+
+// WriteMap writes the start address and a line for each region,
+// with its size, and the total.
+func (m *IPLMap) WriteMap(w io.Writer) {
+	if m.Started {
+		fmt.Fprintf(w, "start   $%06x\n", m.Start)
+	} else {
+		fmt.Fprintf(w, "start   none (no BNZ is taken)\n")
+	}
+	for _, r := range m.Regions {
+		fmt.Fprintf(w, "region  %s  %8d bytes\n", r, r.End-r.Start)
+	}
+	fmt.Fprintf(w, "total   %d bytes in %d regions, from %d IPL pairs\n", len(m.Mem), len(m.Regions), m.Pairs)
+}
+
+// WriteHex writes the loaded bytes, 16 to a line, like
+// "000100: 04 05 c0 ...", for diffing.  Addresses not loaded
+// are shown as "..".
+func (m *IPLMap) WriteHex(w io.Writer) {
+	var lines []uint
+	seen := make(map[uint]bool)
+	for addr := range m.Mem {
+		if line := addr &^ 15; !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	for _, line := range lines {
+		fmt.Fprintf(w, "%06x:", line)
+		for addr := line; addr < line+16; addr++ {
+			if val, ok := m.Mem[addr]; ok {
+				fmt.Fprintf(w, " %02x", val)
+			} else {
+				fmt.Fprintf(w, " ..")
+			}
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
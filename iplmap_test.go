@@ -0,0 +1,106 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestDecompileIPL checks DecompileIPL against a Vm running the same IPL.
+func TestDecompileIPL(t *testing.T) {
+	ipl := CreateIPL(assembleText(fillProgram + "\n\torg $20000\n\tfcb 7\n"))
+	m, err := DecompileIPL(ipl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, regions := IPLRegions(ipl)
+	if !m.Started || m.Start != vm.PC() {
+		t.Errorf("start is %x, want %x", m.Start, vm.PC())
+	}
+	if fmt.Sprint(m.Regions) != fmt.Sprint(regions) {
+		t.Errorf("regions are %v, want %v", m.Regions, regions)
+	}
+	for addr, val := range m.Mem {
+		if vm.Peek(addr) != val {
+			t.Errorf("at %x: %02x, want %02x", addr, val, vm.Peek(addr))
+		}
+	}
+
+	var sb strings.Builder
+	m.WriteMap(&sb)
+	want := `start   $000100
+region  $000100-$000118        25 bytes
+region  $020000-$020000         1 bytes
+total   26 bytes in 2 regions, from 90 IPL pairs
+`
+	if sb.String() != want {
+		t.Errorf("got map:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+// TestDecompileIPLReadsData checks that M, as a source, reads as the
+// data byte during IPL, by comparing DecompileIPL with a Vm.
+func TestDecompileIPLReadsData(t *testing.T) {
+	ipl := []byte{
+		0x07, 0x05, // setl 5
+		0x63, 0x09, // mv m,l, so L = 9
+		0x04, 0x22, // seta $22
+		0x44, 0x00, // mv a,m
+		0x64, 0x33, // mv m,m, so $33 goes to RAM at W
+		0x0A, 0x00, // incw
+		0x60, 0x44, // mv m,a, so A = $44
+		0x44, 0x00, // mv a,m
+	}
+	m, err := DecompileIPL(ipl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, regions := IPLRegions(ipl)
+	if fmt.Sprint(m.Regions) != fmt.Sprint(regions) {
+		t.Errorf("regions %v, want %v", m.Regions, regions)
+	}
+	for _, r := range regions {
+		for addr := r.Start; addr < r.End; addr++ {
+			if got, want := m.Peek(addr), vm.Peek(addr); got != want {
+				t.Errorf("at $%06x got $%02x, want $%02x", addr, got, want)
+			}
+		}
+	}
+	if m.Mem[9] != 0x33 || m.Mem[10] != 0x44 {
+		t.Errorf("got %v", m.Mem)
+	}
+}
+
+func TestDecompileIPLHandwritten(t *testing.T) {
+	ipl := []byte{
+		0x04, 0x11, // seta $11
+		0xC3, 0, // sta q3
+		0x07, 0x05, // setl 5
+		0x44, 0, // mv a,m
+		0x0A, 0, // incw
+		0x0A, 0, // incw
+		0x93, 0, // ldb q3
+		0x63, 0, // mv m,l, so L = 0
+		0x44, 0, // mv a,m
+	}
+	m, err := DecompileIPL(ipl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Started {
+		t.Errorf("started at %x", m.Start)
+	}
+	var sb strings.Builder
+	m.WriteHex(&sb)
+	want := "000000: .. .. .. 11 .. 11 .. .. .. .. .. .. .. .. .. ..\n" +
+		"110000: 11 .. .. .. .. .. .. .. .. .. .. .. .. .. .. ..\n"
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+
+	for _, bad := range [][]byte{{0x04}, {0x46, 0}, {0x00, 0}} {
+		if _, err := DecompileIPL(bad); err == nil {
+			t.Errorf("DecompileIPL(% x) should fail", bad)
+		}
+	}
+}
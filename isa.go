@@ -0,0 +1,288 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Op is one instruction in the ISA.
+type Op struct {
+	Mnemonic string
+	Pattern  string // the opcode bits, most significant first: 0 and 1 are fixed, letters are operand fields
+	//                 (field r is A, B, H, or L, spelled as the last letter of the mnemonic, like lda)
+	Args     string // assembler operands: "reg,reg" (fields f and t), "quick" (field q), "byte" (immediate), or ""
+	Size     int    // bytes, including an immediate byte
+	Cycles   int    // clock cycles, FETCH and EXECUTE
+	Optional bool   // a standard machine may not have it
+	Summary  string
+
+	exec func(vm *Vm, op *Op, t byte) // nil means the Vm stops
+}
+
+// ISA lists every instruction.  The assembler, the emulator, the disassembler,
+// CreateIPL, and the opcode table in architecture.md all come from it.
+var ISA = []*Op{
+	{"mv", "01fffttt", "reg,reg", 1, 2, false, "Move register f to register t.", execMV},
+	{"ld", "10rrqqqq", "quick", 1, 2, false, "Load register r from quick register q.", execLD},
+	{"st", "11rrqqqq", "quick", 1, 2, false, "Store register r to quick register q.", execST},
+	{"set", "000001rr", "byte", 2, 2, false, "Set register r to the immediate byte.", execSET},
+	{"inca", "00001000", "", 1, 2, false, "Increment A.", execINCA},
+	{"deca", "00001001", "", 1, 2, true, "Decrement A.", execDECA},
+	{"incw", "00001010", "", 1, 2, false, "Increment W, carrying from L to H to B.", execINCW},
+	{"decw", "00001011", "", 1, 2, true, "Decrement W, borrowing from L to H to B.", execDECW},
+	{"bnz", "00001100", "", 1, 2, false, "Branch to W if A is not zero.", execBNZ},
+	{"stop", "00000000", "", 1, 2, true, "Stop the emulator.", nil},
+}
+
+// Opcodes decodes each opcode to its Op, or nil if it is undefined.
+var Opcodes [256]*Op
+
+// spelling is an instruction as the assembler names it:
+// the Op, and the value of its field r, if it has one.
+type spelling struct {
+	op *Op
+	r  uint
+}
+
+// ops finds each instruction by its mnemonic.
+// (It is a variable, not built by init, as the assembler's init uses it.)
+var ops = makeSpellings()
+
+func makeSpellings() map[string]spelling {
+	m := make(map[string]spelling)
+	for _, op := range ISA {
+		if !op.HasField('r') {
+			m[op.Mnemonic] = spelling{op, 0}
+			continue
+		}
+		for r := uint(0); r < 4; r++ {
+			m[op.Mnemonic+regLetters[r:r+1]] = spelling{op, r}
+		}
+	}
+	return m
+}
+
+func init() {
+	for _, op := range ISA {
+		if len(op.Pattern) != 8 {
+			panic("bad pattern: " + op.Pattern)
+		}
+		for t := 0; t < 256; t++ {
+			if op.Matches(byte(t)) {
+				if Opcodes[t] != nil {
+					panic(fmt.Sprintf("opcode %02x is both %s and %s", t, Opcodes[t].Mnemonic, op.Mnemonic))
+				}
+				Opcodes[t] = op
+			}
+		}
+	}
+}
+
+// HasField tells if the pattern has the operand field named by the letter.
+func (op *Op) HasField(letter byte) bool {
+	return strings.IndexByte(op.Pattern, letter) >= 0
+}
+
+// Name is the mnemonic of opcode t, as the assembler spells it:
+// with the register of field r, if there is one, like lda.
+func (op *Op) Name(t byte) string {
+	if op.HasField('r') {
+		return op.Mnemonic + regLetters[op.Field(t, 'r'):op.Field(t, 'r')+1]
+	}
+	return op.Mnemonic
+}
+
+// Matches tells if the opcode has the fixed bits of the pattern.
+func (op *Op) Matches(t byte) bool {
+	for i, c := range op.Pattern {
+		bit := (t >> (7 - i)) & 1
+		if (c == '0' && bit != 0) || (c == '1' && bit != 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Field extracts the operand field named by the letter from the opcode.
+func (op *Op) Field(t byte, letter byte) uint {
+	var z uint
+	for i := 0; i < 8; i++ {
+		if op.Pattern[i] == letter {
+			z = z<<1 | uint((t>>(7-i))&1)
+		}
+	}
+	return z
+}
+
+// Encode returns the opcode with its operand fields set to the values,
+// in the order the fields appear in the pattern.
+func (op *Op) Encode(values ...uint) byte {
+	var t byte
+	var letters []byte
+	for i := 0; i < 8; i++ {
+		switch c := op.Pattern[i]; c {
+		case '0':
+		case '1':
+			t |= 1 << (7 - i)
+		default:
+			if len(letters) == 0 || letters[len(letters)-1] != c {
+				letters = append(letters, c)
+			}
+		}
+	}
+	if len(values) != len(letters) {
+		panic(fmt.Sprintf("%s takes %d operand fields, got %d", op.Mnemonic, len(letters), len(values)))
+	}
+	for f, letter := range letters {
+		v := values[f]
+		for i := 7; i >= 0; i-- {
+			if op.Pattern[i] == letter {
+				t |= byte(v&1) << (7 - i)
+				v >>= 1
+			}
+		}
+	}
+	return t
+}
+
+// Encode returns the opcode for the mnemonic, with its operand fields.
+// It panics if there is no such instruction.
+func Encode(mnemonic string, values ...uint) byte {
+	sp, ok := ops[mnemonic]
+	if !ok {
+		panic("no instruction " + mnemonic)
+	}
+	if sp.op.HasField('r') {
+		values = append([]uint{sp.r}, values...) // field r comes first
+	}
+	return sp.op.Encode(values...)
+}
+
+// ReadsPortF tells if opcode t reads port F: MV f,r.
+func ReadsPortF(t byte) bool {
+	op := Opcodes[t]
+	return op != nil && op.Mnemonic == "mv" && op.Field(t, 'f') == 6
+}
+
+// IsBranch tells if opcode t is BNZ, which branches when A is not zero.
+func IsBranch(t byte) bool {
+	op := Opcodes[t]
+	return op != nil && op.Mnemonic == "bnz"
+}
+
+// CyclesOf is the number of clock cycles the opcode takes.
+func CyclesOf(t byte) int {
+	if op := Opcodes[t]; op != nil {
+		return op.Cycles
+	}
+	return CyclesPerStep
+}
+
+// WriteOpcodeTable writes the ISA as a markdown table, for architecture.md.
+func WriteOpcodeTable(w io.Writer) {
+	fmt.Fprintf(w, "| Opcode | Mnemonic | Operands | Bytes | Cycles | Summary |\n")
+	fmt.Fprintf(w, "|--------|----------|----------|-------|--------|---------|\n")
+	for _, op := range ISA {
+		summary := op.Summary
+		if op.Optional {
+			summary += " (optional)"
+		}
+		args := op.Args
+		if args == "" {
+			args = "-"
+		}
+		mnemonic := strings.ToUpper(op.Mnemonic)
+		if op.HasField('r') {
+			mnemonic += "r"
+		}
+		fmt.Fprintf(w, "| `%s` | `%s` | %s | %d | %d | %s |\n", op.Pattern, mnemonic, args, op.Size, op.Cycles, summary)
+	}
+}
+
+// The opcode table in architecture.md lies between these lines.
+const (
+	OpcodeTableBegin = "<!-- begin opcode table: go run owl-isa/owl-isa.go -update architecture.md -->"
+	OpcodeTableEnd   = "<!-- end opcode table -->"
+)
+
+// UpdateOpcodeTable returns the document with the opcode table
+// between its markers rewritten from the ISA.
+func UpdateOpcodeTable(doc string) (string, error) {
+	i := strings.Index(doc, OpcodeTableBegin)
+	j := strings.Index(doc, OpcodeTableEnd)
+	if i < 0 || j < i {
+		return "", fmt.Errorf("cannot find the opcode table markers")
+	}
+	var sb strings.Builder
+	WriteOpcodeTable(&sb)
+	return doc[:i+len(OpcodeTableBegin)] + "\n" + sb.String() + doc[j:], nil
+}
+
+func execMV(vm *Vm, op *Op, t byte) {
+	from, to := byte(op.Field(t, 'f')), byte(op.Field(t, 't'))
+	val := vm.GetReg(from)
+	vm.log("    MV value $%x from %s to %s", val, RegNames[from], RegNames[to])
+	vm.PutReg(to, val)
+}
+
+func execLD(vm *Vm, op *Op, t byte) {
+	to, addr := byte(op.Field(t, 'r')), op.Field(t, 'q')
+	val := vm.read(addr)
+	vm.log("    LD%s value $%x from addr $%x", RegNames[to], val, addr)
+	vm.cycle.Addr = addr
+	vm.cycle.Strobes |= ReadM
+	vm.PutReg(to, val)
+}
+
+func execST(vm *Vm, op *Op, t byte) {
+	from, addr := byte(op.Field(t, 'r')), op.Field(t, 'q')
+	val := vm.GetReg(from)
+	vm.log("    ST%s value $%x to addr $%x", RegNames[from], val, addr)
+	vm.cycle.Addr, vm.cycle.Data = addr, val
+	vm.cycle.Strobes |= WriteM
+	vm.write(addr, val)
+	vm.events++
+}
+
+func execSET(vm *Vm, op *Op, t byte) {
+	to := byte(op.Field(t, 'r'))
+	vm.log("    SET%s immediate $%x", RegNames[to], vm.imm)
+	vm.cycle.Addr = vm.pc
+	vm.PutReg(to, vm.imm)
+	vm.pc++
+}
+
+func execINCA(vm *Vm, op *Op, t byte) {
+	vm.a++
+	vm.log("    INCA becomes %x", vm.a)
+	vm.cycle.Strobes |= LatchA
+}
+
+func execDECA(vm *Vm, op *Op, t byte) {
+	vm.a--
+	vm.log("    DECA becomes %x", vm.a)
+	vm.cycle.Strobes |= LatchA
+}
+
+func execINCW(vm *Vm, op *Op, t byte) {
+	vm.b, vm.h, vm.l = BhlSplit(vm.W() + 1)
+	vm.log("    INCW becomes %x", vm.W())
+	vm.cycle.Strobes |= LatchB | LatchH | LatchL
+}
+
+func execDECW(vm *Vm, op *Op, t byte) {
+	vm.b, vm.h, vm.l = BhlSplit(vm.W() - 1)
+	vm.log("    DECW becomes %x", vm.W())
+	vm.cycle.Strobes |= LatchB | LatchH | LatchL
+}
+
+func execBNZ(vm *Vm, op *Op, t byte) {
+	if vm.a == 0 {
+		vm.log("    BNZ (not taken)")
+	} else {
+		vm.log("    BNZ ... branching to %x", vm.W())
+		vm.pc = vm.W()
+		vm.cycle.Strobes |= LoadPC
+	}
+}
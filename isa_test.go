@@ -0,0 +1,109 @@
+package ABhL // pronounced "owl"
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestISAAssembler checks that each defined opcode disassembles
+// to text that assembles back to the same bytes.
+func TestISAAssembler(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		op := Opcodes[i]
+		if op == nil {
+			continue
+		}
+		text, size := Disasm(byte(i), 0x5A)
+		mod := assembleText("\t" + text)
+		var got []byte
+		for _, pair := range mod.generated {
+			got = append(got, pair.data)
+		}
+		want := []byte{byte(i), 0x5A}[:size]
+		if string(got) != string(want) || size != op.Size {
+			t.Errorf("%02x: %q assembles to % x, want % x", i, text, got, want)
+		}
+	}
+}
+
+// TestISAEmulator checks that the emulator executes exactly the
+// defined opcodes, taking their size and cycles.
+func TestISAEmulator(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		op := Opcodes[i]
+		if op != nil && op.Args == "reg,reg" && (op.Field(byte(i), 'f') > 4 || op.Field(byte(i), 't') > 4) {
+			continue // the ports need a Ports
+		}
+		vm := &Vm{Logf: quiet}
+		vm.Poke(0, byte(i))
+		vm.Poke(1, 0x5A)
+		ok := vm.Steps(1) // A is 0, so bnz is not taken
+		if defined := op != nil && op.exec != nil; ok != defined {
+			t.Errorf("%02x: Steps = %v, want %v", i, ok, defined)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if vm.PC() != uint(op.Size) {
+			t.Errorf("%02x %s: PC = %d, want %d", i, op.Name(byte(i)), vm.PC(), op.Size)
+		}
+		if vm.Cycles() != uint64(op.Cycles) || op.Cycles != CyclesPerStep {
+			t.Errorf("%02x %s: %d cycles, want %d", i, op.Name(byte(i)), vm.Cycles(), op.Cycles)
+		}
+	}
+}
+
+func TestISAEncode(t *testing.T) {
+	for _, it := range []struct {
+		mnemonic string
+		values   []uint
+		want     byte
+	}{
+		{"mv", []uint{6, 0}, 0x70},
+		{"mv", []uint{0, 4}, 0x44},
+		{"ldb", []uint{15}, 0x9F},
+		{"stl", []uint{3}, 0xF3},
+		{"setl", nil, 0x07},
+		{"bnz", nil, 0x0C},
+		{"stop", nil, 0x00},
+	} {
+		got := Encode(it.mnemonic, it.values...)
+		if got != it.want {
+			t.Errorf("Encode(%q, %v) = %02x, want %02x", it.mnemonic, it.values, got, it.want)
+		}
+		if op := Opcodes[got]; op == nil || op.Name(got) != it.mnemonic {
+			t.Errorf("%02x does not decode to %q", got, it.mnemonic)
+		}
+	}
+}
+
+// TestOpcodePredicates checks ReadsPortF and IsBranch
+// against the disassembler and DecodeControls.
+func TestOpcodePredicates(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		text, _ := Disasm(byte(i), 0)
+		controls := DecodeControls(byte(i), true, 1)
+		if got := ReadsPortF(byte(i)); got != strings.HasPrefix(text, "mv f,") || got != (controls&ReadF != 0) {
+			t.Errorf("%02x %q: ReadsPortF is %v", i, text, got)
+		}
+		if got := IsBranch(byte(i)); got != (text == "bnz") || got != (controls&LoadPC != 0) {
+			t.Errorf("%02x %q: IsBranch is %v", i, text, got)
+		}
+	}
+}
+
+func TestOpcodeTableDoc(t *testing.T) {
+	doc, err := ioutil.ReadFile("architecture.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := UpdateOpcodeTable(string(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != string(doc) {
+		t.Errorf("architecture.md is stale; run: go run owl-isa/owl-isa.go -update architecture.md")
+	}
+}
@@ -0,0 +1,464 @@
+package netsim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// pins binds the pin names of one part to nets.
+type pins struct {
+	ref, typ string
+	nets     map[string]int
+	attrs    map[string]string
+	dir      string
+}
+
+// pin returns the net of a pin, or -1 if it is not connected.
+func (pp *pins) pin(name string) int {
+	if n, ok := pp.nets[name]; ok {
+		return n
+	}
+	return -1
+}
+
+// bus returns the nets of pins prefix0, prefix1, ... prefix(n-1).
+func (pp *pins) bus(prefix string, n int) []int {
+	z := make([]int, n)
+	for i := range z {
+		z[i] = pp.pin(fmt.Sprintf("%s%d", prefix, i))
+	}
+	return z
+}
+
+type model struct {
+	pins  []string
+	build func(s *Sim, pp *pins) (part, error)
+}
+
+func names(prefix string, n int) (z []string) {
+	for i := 0; i < n; i++ {
+		z = append(z, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return
+}
+
+func join(lists ...[]string) (z []string) {
+	for _, list := range lists {
+		z = append(z, list...)
+	}
+	return
+}
+
+// gatePins names the pins of the gates in a package, like "1A", "1B", "1Y".
+func gatePins(gates int, ins ...string) (z []string) {
+	for g := 1; g <= gates; g++ {
+		for _, in := range append(ins, "Y") {
+			z = append(z, fmt.Sprintf("%d%s", g, in))
+		}
+	}
+	return
+}
+
+var models = map[string]model{
+	"7400":  {gatePins(4, "A", "B"), buildGates(4, 2, func(in []bool) bool { return !(in[0] && in[1]) })},
+	"7404":  {gatePins(6, "A"), buildGates(6, 1, func(in []bool) bool { return !in[0] })},
+	"7474":  {join([]string{"1D", "1CLK", "1/PRE", "1/CLR", "1Q", "1/Q", "2D", "2CLK", "2/PRE", "2/CLR", "2Q", "2/Q"}), build7474},
+	"74161": {join(names("P", 4), names("Q", 4), []string{"CP", "/PE", "CEP", "CET", "TC", "/MR"}), build74161},
+	"74169": {join(names("P", 4), names("Q", 4), []string{"CP", "/PE", "/CEP", "/CET", "U/D", "/TC"}), build74169},
+	"74377": {join(names("D", 8), names("Q", 8), []string{"/E", "CP"}), build74377},
+	"74541": {join(names("A", 8), names("Y", 8), []string{"/OE1", "/OE2"}), build74541},
+	"74688": {join(names("P", 8), names("Q", 8), []string{"/G", "/P=Q"}), build74688},
+	"2716":  {join(names("A", 11), names("O", 8), []string{"/CE", "/OE"}), build2716},
+	"sram":  {join(names("A", 24), names("D", 8), []string{"/CE", "/OE", "/WE"}), buildSRAM},
+	"port":  {join(names("D", 8), []string{"/RD", "/WR"}), buildPort},
+	"pico":  {join(names("D", 8), []string{"/DRV", "IPL_N"}), buildPico},
+}
+
+// combinational is a part with no clock.
+type combinational struct{}
+
+func (combinational) edge(s *Sim) {}
+func (combinational) commit()     {}
+
+// gates is a package of identical gates, like the 7400.
+type gates struct {
+	combinational
+	ins [][]int
+	out []int
+	fn  func(in []bool) bool
+}
+
+func buildGates(n, inputs int, fn func(in []bool) bool) func(s *Sim, pp *pins) (part, error) {
+	return func(s *Sim, pp *pins) (part, error) {
+		g := &gates{fn: fn}
+		for i := 1; i <= n; i++ {
+			var ins []int
+			for _, in := range "AB"[:inputs] {
+				ins = append(ins, pp.pin(fmt.Sprintf("%d%c", i, in)))
+			}
+			g.ins = append(g.ins, ins)
+			g.out = append(g.out, pp.pin(fmt.Sprintf("%dY", i)))
+		}
+		return g, nil
+	}
+}
+
+func (g *gates) eval(s *Sim) {
+	for i, ins := range g.ins {
+		in := make([]bool, len(ins))
+		for j, n := range ins {
+			in[j] = s.get(n)
+		}
+		s.drive(g.out[i], g.fn(in))
+	}
+}
+
+// clocked tracks the clock pin of a sequential part.
+type clocked struct {
+	clk  int
+	was  bool // the clock, at the last edge call
+	rose bool // the clock rose, at the last edge call
+}
+
+func (c *clocked) sample(s *Sim) bool {
+	now := s.get(c.clk)
+	c.rose = now && !c.was
+	c.was = now
+	return c.rose
+}
+
+// The 7474 is two D flip-flops, with asynchronous preset and clear.
+type chip7474 struct {
+	ffs [2]struct {
+		clocked
+		d, pre, clr, q, qn int
+		state, next        bool
+	}
+}
+
+func build7474(s *Sim, pp *pins) (part, error) {
+	c := &chip7474{}
+	for i := range c.ffs {
+		ff := &c.ffs[i]
+		p := func(name string) int { return pp.pin(fmt.Sprintf("%d%s", i+1, name)) }
+		ff.clk, ff.d, ff.pre, ff.clr, ff.q, ff.qn = p("CLK"), p("D"), p("/PRE"), p("/CLR"), p("Q"), p("/Q")
+	}
+	return c, nil
+}
+
+func (c *chip7474) eval(s *Sim) {
+	for i := range c.ffs {
+		ff := &c.ffs[i]
+		if !s.get(ff.pre) {
+			ff.state = true
+		} else if !s.get(ff.clr) {
+			ff.state = false
+		}
+		s.drive(ff.q, ff.state)
+		s.drive(ff.qn, !ff.state)
+	}
+}
+
+func (c *chip7474) edge(s *Sim) {
+	for i := range c.ffs {
+		ff := &c.ffs[i]
+		if ff.sample(s) {
+			ff.next = s.get(ff.d)
+		}
+	}
+}
+
+func (c *chip7474) commit() {
+	for i := range c.ffs {
+		ff := &c.ffs[i]
+		if ff.rose {
+			ff.state = ff.next
+		}
+	}
+}
+
+// The 74161 is a synchronous 4-bit binary counter, with synchronous load
+// and asynchronous clear.  TC is high when CET is high and the count is 15.
+type chip74161 struct {
+	clocked
+	p, q                 []int
+	pe, cep, cet, tc, mr int
+	state, next          uint
+}
+
+func build74161(s *Sim, pp *pins) (part, error) {
+	return &chip74161{clocked: clocked{clk: pp.pin("CP")}, p: pp.bus("P", 4), q: pp.bus("Q", 4),
+		pe: pp.pin("/PE"), cep: pp.pin("CEP"), cet: pp.pin("CET"), tc: pp.pin("TC"), mr: pp.pin("/MR")}, nil
+}
+
+func (c *chip74161) eval(s *Sim) {
+	if !s.get(c.mr) {
+		c.state = 0
+	}
+	s.driveBits(c.q, c.state)
+	s.drive(c.tc, s.get(c.cet) && c.state == 15)
+}
+
+func (c *chip74161) edge(s *Sim) {
+	if !c.sample(s) {
+		return
+	}
+	switch {
+	case !s.get(c.pe):
+		c.next = s.getBits(c.p)
+	case s.get(c.cep) && s.get(c.cet):
+		c.next = (c.state + 1) & 15
+	default:
+		c.next = c.state
+	}
+}
+
+func (c *chip74161) commit() {
+	if c.rose {
+		c.state = c.next
+	}
+}
+
+// The 74169 is a synchronous 4-bit up/down binary counter, with synchronous load.
+// /TC is low when /CET is low and the count is 15 going up, or 0 going down.
+type chip74169 struct {
+	clocked
+	p, q                  []int
+	pe, cep, cet, ud, tcn int
+	state, next           uint
+}
+
+func build74169(s *Sim, pp *pins) (part, error) {
+	return &chip74169{clocked: clocked{clk: pp.pin("CP")}, p: pp.bus("P", 4), q: pp.bus("Q", 4),
+		pe: pp.pin("/PE"), cep: pp.pin("/CEP"), cet: pp.pin("/CET"), ud: pp.pin("U/D"), tcn: pp.pin("/TC")}, nil
+}
+
+func (c *chip74169) eval(s *Sim) {
+	s.driveBits(c.q, c.state)
+	end := uint(0)
+	if s.get(c.ud) {
+		end = 15
+	}
+	s.drive(c.tcn, !(!s.get(c.cet) && c.state == end))
+}
+
+func (c *chip74169) edge(s *Sim) {
+	if !c.sample(s) {
+		return
+	}
+	switch {
+	case !s.get(c.pe):
+		c.next = s.getBits(c.p)
+	case !s.get(c.cep) && !s.get(c.cet):
+		if s.get(c.ud) {
+			c.next = (c.state + 1) & 15
+		} else {
+			c.next = (c.state - 1) & 15
+		}
+	default:
+		c.next = c.state
+	}
+}
+
+func (c *chip74169) commit() {
+	if c.rose {
+		c.state = c.next
+	}
+}
+
+// The 74377 is eight D flip-flops with a common clock enable.
+type chip74377 struct {
+	clocked
+	d, q        []int
+	e           int
+	state, next uint
+}
+
+func build74377(s *Sim, pp *pins) (part, error) {
+	return &chip74377{clocked: clocked{clk: pp.pin("CP")}, d: pp.bus("D", 8), q: pp.bus("Q", 8), e: pp.pin("/E")}, nil
+}
+
+func (c *chip74377) eval(s *Sim) {
+	s.driveBits(c.q, c.state)
+}
+
+func (c *chip74377) edge(s *Sim) {
+	if !c.sample(s) {
+		return
+	}
+	c.next = c.state
+	if !s.get(c.e) {
+		c.next = s.getBits(c.d)
+	}
+}
+
+func (c *chip74377) commit() {
+	if c.rose {
+		c.state = c.next
+	}
+}
+
+// The 74541 is an octal buffer, with tri-state outputs enabled
+// when both /OE1 and /OE2 are low.
+type chip74541 struct {
+	combinational
+	a, y     []int
+	oe1, oe2 int
+}
+
+func build74541(s *Sim, pp *pins) (part, error) {
+	return &chip74541{a: pp.bus("A", 8), y: pp.bus("Y", 8), oe1: pp.pin("/OE1"), oe2: pp.pin("/OE2")}, nil
+}
+
+func (c *chip74541) eval(s *Sim) {
+	if !s.get(c.oe1) && !s.get(c.oe2) {
+		s.driveBits(c.y, s.getBits(c.a))
+	}
+}
+
+// The 74688 is an 8-bit identity comparator: /P=Q is low when /G is low and P equals Q.
+type chip74688 struct {
+	combinational
+	p, q    []int
+	g, peqq int
+}
+
+func build74688(s *Sim, pp *pins) (part, error) {
+	return &chip74688{p: pp.bus("P", 8), q: pp.bus("Q", 8), g: pp.pin("/G"), peqq: pp.pin("/P=Q")}, nil
+}
+
+func (c *chip74688) eval(s *Sim) {
+	s.drive(c.peqq, !(!s.get(c.g) && s.getBits(c.p) == s.getBits(c.q)))
+}
+
+// The 2716 is a 2K by 8 EPROM.  Its contents come from one of the
+// decode ROMs (attr "decode": "0" to "3"), or from a 2048-byte
+// file (attr "image", relative to the netlist).
+type chip2716 struct {
+	combinational
+	a, o   []int
+	ce, oe int
+	rom    []byte
+}
+
+func build2716(s *Sim, pp *pins) (part, error) {
+	c := &chip2716{a: pp.bus("A", 11), o: pp.bus("O", 8), ce: pp.pin("/CE"), oe: pp.pin("/OE")}
+	if lane, ok := pp.attrs["decode"]; ok {
+		n, err := strconv.Atoi(lane)
+		if err != nil || n < 0 || n > 3 {
+			return nil, fmt.Errorf("bad decode lane %q", lane)
+		}
+		c.rom = DecodeROM(n)
+	} else if image, ok := pp.attrs["image"]; ok {
+		rom, err := ioutil.ReadFile(filepath.Join(pp.dir, image))
+		if err != nil {
+			return nil, err
+		}
+		if len(rom) != 2048 {
+			return nil, fmt.Errorf("image %q has %d bytes, want 2048", image, len(rom))
+		}
+		c.rom = rom
+	} else {
+		return nil, fmt.Errorf("needs attr \"decode\" or \"image\"")
+	}
+	return c, nil
+}
+
+func (c *chip2716) eval(s *Sim) {
+	if !s.get(c.ce) && !s.get(c.oe) {
+		s.driveBits(c.o, uint(c.rom[s.getBits(c.a)]))
+	}
+}
+
+// SRAM is a 16M by 8 static RAM.  While /CE and /WE are low,
+// it writes the data bus; while /CE and /OE are low (and /WE high),
+// it drives the data bus.
+type SRAM struct {
+	combinational
+	a, d       []int
+	ce, oe, we int
+	mem        map[uint]byte
+}
+
+func buildSRAM(s *Sim, pp *pins) (part, error) {
+	if s.RAM != nil {
+		return nil, fmt.Errorf("only one sram is supported")
+	}
+	s.RAM = &SRAM{a: pp.bus("A", 24), d: pp.bus("D", 8), ce: pp.pin("/CE"), oe: pp.pin("/OE"), we: pp.pin("/WE"), mem: make(map[uint]byte)}
+	return s.RAM, nil
+}
+
+func (r *SRAM) eval(s *Sim) {
+	if s.get(r.ce) {
+		return
+	}
+	if !s.get(r.we) {
+		r.mem[s.getBits(r.a)] = byte(s.getBits(r.d))
+	} else if !s.get(r.oe) {
+		s.driveBits(r.d, uint(r.mem[s.getBits(r.a)]))
+	}
+}
+
+// Peek returns the byte at addr.
+func (r *SRAM) Peek(addr uint) byte {
+	return r.mem[addr]
+}
+
+// Port is an I/O port (attr "name": "E", "F", or "G").
+// While /RD is low, it drives Input onto the data bus.
+// While /WR is low, it takes the data bus into Output, and sets Wrote.
+type Port struct {
+	combinational
+	d      []int
+	rd, wr int
+	Input  byte
+	Output byte
+	Wrote  bool
+}
+
+func buildPort(s *Sim, pp *pins) (part, error) {
+	name := pp.attrs["name"]
+	if name == "" {
+		return nil, fmt.Errorf("needs attr \"name\"")
+	}
+	p := &Port{d: pp.bus("D", 8), rd: pp.pin("/RD"), wr: pp.pin("/WR")}
+	s.Ports[name] = p
+	return p, nil
+}
+
+func (p *Port) eval(s *Sim) {
+	if !s.get(p.rd) {
+		s.driveBits(p.d, uint(p.Input))
+	}
+	if !s.get(p.wr) {
+		p.Output, p.Wrote = byte(s.getBits(p.d)), true
+	}
+}
+
+// Pico is the IPL device.  IPL_N is low while IPL is true,
+// and while /DRV is low, it drives Data onto the data bus.
+type Pico struct {
+	combinational
+	d    []int
+	drv  int
+	iplN int
+	IPL  bool
+	Data byte
+}
+
+func buildPico(s *Sim, pp *pins) (part, error) {
+	if s.Pico != nil {
+		return nil, fmt.Errorf("only one pico is supported")
+	}
+	s.Pico = &Pico{d: pp.bus("D", 8), drv: pp.pin("/DRV"), iplN: pp.pin("IPL_N")}
+	return s.Pico, nil
+}
+
+func (p *Pico) eval(s *Sim) {
+	s.drive(p.iplN, !p.IPL)
+	if !s.get(p.drv) {
+		s.driveBits(p.d, uint(p.Data))
+	}
+}
@@ -0,0 +1,218 @@
+package netsim
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+// The control word, from the decode ROMs: four 2716 EPROMs, each
+// giving 8 bits.  Signals ending in _N are active low.
+// The ROM address is T (A0-A7), PHASE (A8, high for EXECUTE),
+// A_NZ (A9, high if A is not zero), and IPL_N (A10, low during IPL).
+const (
+	ADDR_PC_N = 1 << iota // PC drives the address bus
+	ADDR_W_N              // W drives the address bus
+	ADDR_Q_N              // the quick address (the low 4 bits of T) drives the address bus
+	PC_INC                // PC counts up
+	PC_LOAD_N             // PC loads W
+	T_LOAD_N              // T latches the data bus
+	RAM_OE_N              // RAM drives the data bus
+	RAM_WE                // RAM takes the data bus (gated by the clock)
+
+	DRV_A_N   // A drives the data bus
+	DRV_B_N   // B drives the data bus
+	DRV_H_N   // H drives the data bus
+	DRV_L_N   // L drives the data bus
+	RD_E_N    // port E drives the data bus
+	RD_F_N    // port F drives the data bus
+	RD_G_N    // port G drives the data bus
+	IPL_DRV_N // the IPL device (the Pico) drives the data bus
+
+	WR_E    // port E takes the data bus (gated by the clock)
+	WR_F    // port F takes the data bus (gated by the clock)
+	WR_G    // port G takes the data bus (gated by the clock)
+	LD_A_N  // A loads the data bus
+	LD_B_N  // B loads the data bus
+	LD_H_N  // H loads the data bus
+	LD_L_N  // L loads the data bus
+	CNT_A_N // A counts
+
+	CNT_W_N // W (L, then carries to H and B) counts
+	UP      // counters count up, else down
+)
+
+// ControlNames names the bits of the control word, in order.
+var ControlNames = []string{
+	"ADDR_PC_N", "ADDR_W_N", "ADDR_Q_N", "PC_INC", "PC_LOAD_N", "T_LOAD_N", "RAM_OE_N", "RAM_WE",
+	"DRV_A_N", "DRV_B_N", "DRV_H_N", "DRV_L_N", "RD_E_N", "RD_F_N", "RD_G_N", "IPL_DRV_N",
+	"WR_E", "WR_F", "WR_G", "LD_A_N", "LD_B_N", "LD_H_N", "LD_L_N", "CNT_A_N",
+	"CNT_W_N", "UP",
+}
+
+// activeLow are the control bits that are asserted when low.
+const activeLow = ADDR_PC_N | ADDR_W_N | ADDR_Q_N | PC_LOAD_N | T_LOAD_N | RAM_OE_N |
+	DRV_A_N | DRV_B_N | DRV_H_N | DRV_L_N | RD_E_N | RD_F_N | RD_G_N | IPL_DRV_N |
+	LD_A_N | LD_B_N | LD_H_N | LD_L_N | CNT_A_N | CNT_W_N
+
+// Indexed by register number, as in the opcodes.
+var driveSignals = []uint32{DRV_A_N, DRV_B_N, DRV_H_N, DRV_L_N, RAM_OE_N, RD_E_N, RD_F_N, RD_G_N}
+var loadSignals = []uint32{LD_A_N, LD_B_N, LD_H_N, LD_L_N, RAM_WE, WR_E, WR_F, WR_G}
+
+// DecodeWord is the control word for a cycle: FETCH or EXECUTE of opcode t,
+// with A nonzero or not, in IPL mode or not.
+//
+// During IPL, the Pico supplies the bytes that would be read from memory
+// at the PC or at W (the opcodes, the immediate bytes, and M),
+// and the PC does not count on FETCH.
+func DecodeWord(t byte, execute, aNonZero, ipl bool) uint32 {
+	var on uint32 // asserted signals, before applying polarity
+	readMem := uint32(RAM_OE_N)
+	if ipl {
+		readMem = IPL_DRV_N
+	}
+	if !execute {
+		on = ADDR_PC_N | T_LOAD_N | readMem
+		if !ipl {
+			on |= PC_INC
+		}
+		return on ^ activeLow
+	}
+
+	op := OWL.Opcodes[t]
+	if op == nil {
+		return ADDR_W_N ^ activeLow // undefined opcodes do nothing
+	}
+	switch op.Mnemonic {
+	case "mv":
+		from, to := op.Field(t, 'f'), op.Field(t, 't')
+		on = ADDR_W_N | driveSignals[from] | loadSignals[to]
+		if from == 4 {
+			on = on&^RAM_OE_N | readMem
+			if to == 4 && !ipl {
+				on &^= RAM_WE // M to M would fight over the bus; it changes nothing
+			}
+		}
+	case "ld":
+		on = ADDR_Q_N | RAM_OE_N | loadSignals[op.Field(t, 'r')]
+	case "st":
+		on = ADDR_Q_N | driveSignals[op.Field(t, 'r')] | RAM_WE
+	case "set":
+		on = ADDR_PC_N | readMem | PC_INC | loadSignals[op.Field(t, 'r')]
+	case "inca":
+		on = ADDR_W_N | CNT_A_N | UP
+	case "deca":
+		on = ADDR_W_N | CNT_A_N
+	case "incw":
+		on = ADDR_W_N | CNT_W_N | UP
+	case "decw":
+		on = ADDR_W_N | CNT_W_N
+	case "bnz":
+		on = ADDR_W_N
+		if aNonZero {
+			on |= PC_LOAD_N
+		}
+	default: // STOP
+		on = ADDR_W_N
+	}
+	return on ^ activeLow
+}
+
+// DecodeROM returns the contents of one of the four decode ROMs:
+// lane 0 has bits 0-7 of the control word, lane 1 bits 8-15, and so on.
+func DecodeROM(lane int) []byte {
+	rom := make([]byte, 2048)
+	for addr := range rom {
+		rom[addr] = byte(DecodeROMWord(uint(addr)) >> (8 * lane))
+	}
+	return rom
+}
+
+// DecodeROMWord is the control word at a decode ROM address.
+func DecodeROMWord(addr uint) uint32 {
+	return DecodeWord(byte(addr), addr&0x100 != 0, addr&0x200 != 0, addr&0x400 == 0)
+}
+
+// romInputs names the decode ROM's address inputs, A0 to A10.
+var romInputs = []string{"T0", "T1", "T2", "T3", "T4", "T5", "T6", "T7", "PHASE", "A_NZ", "IPL_N"}
+
+// asserted tells if the control signal (one bit) is asserted in the word.
+func asserted(word uint32, bit uint32) bool {
+	return (word^activeLow)&bit != 0
+}
+
+// WriteTruthTable writes which control signals are asserted, for each opcode:
+// in the FETCH cycle (the same for all opcodes) and the EXECUTE cycle.
+// Rows for A not zero, and for IPL, are given only where they differ.
+func WriteTruthTable(w io.Writer) {
+	signals := func(word uint32) string {
+		var names []string
+		for i, name := range ControlNames {
+			if asserted(word, 1<<i) {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, " ")
+	}
+	fmt.Fprintf(w, "%-8s %-7s %-12s %-9s %s\n", "phase", "opcode", "instruction", "when", "asserted signals")
+	fmt.Fprintf(w, "%-8s %-7s %-12s %-9s %s\n", "FETCH", "any", "", "", signals(DecodeWord(0, false, false, false)))
+	fmt.Fprintf(w, "%-8s %-7s %-12s %-9s %s\n", "FETCH", "any", "", "IPL", signals(DecodeWord(0, false, false, true)))
+	for t := 0; t < 256; t++ {
+		text, _ := OWL.Disasm(byte(t), 0)
+		text, _, _ = strings.Cut(text, " $")
+		if text == "fcb" {
+			text = "undefined"
+		}
+		base := DecodeWord(byte(t), true, false, false)
+		fmt.Fprintf(w, "%-8s %02x      %-12s %-9s %s\n", "EXECUTE", t, text, "", signals(base))
+		if nz := DecodeWord(byte(t), true, true, false); nz != base {
+			fmt.Fprintf(w, "%-8s %02x      %-12s %-9s %s\n", "EXECUTE", t, text, "A_NZ", signals(nz))
+		}
+		if ipl := DecodeWord(byte(t), true, false, true); ipl != base {
+			fmt.Fprintf(w, "%-8s %02x      %-12s %-9s %s\n", "EXECUTE", t, text, "IPL", signals(ipl))
+		}
+	}
+}
+
+// WriteEquations writes a minimized sum-of-products equation for each
+// control signal, in PALASM style: "*" is AND, "+" is OR, and "/" is NOT.
+// An active-low signal X_N has the equation for /X_N.
+func WriteEquations(w io.Writer) {
+	for i, name := range ControlNames {
+		bit := uint32(1) << i
+		var minterms []uint
+		for addr := uint(0); addr < 2048; addr++ {
+			if asserted(DecodeROMWord(addr), bit) {
+				minterms = append(minterms, addr)
+			}
+		}
+		lhs := name
+		if activeLow&bit != 0 {
+			lhs = "/" + name
+		}
+		var terms []string
+		for _, c := range minimize(minterms, len(romInputs)) {
+			var lits []string
+			for v := len(romInputs) - 1; v >= 0; v-- {
+				if c.care&(1<<v) == 0 {
+					continue
+				}
+				if c.val&(1<<v) != 0 {
+					lits = append(lits, romInputs[v])
+				} else {
+					lits = append(lits, "/"+romInputs[v])
+				}
+			}
+			if len(lits) == 0 {
+				lits = []string{"VCC"}
+			}
+			terms = append(terms, strings.Join(lits, " * "))
+		}
+		if len(terms) == 0 {
+			terms = []string{"GND"}
+		}
+		fmt.Fprintf(w, "%s = %s\n", lhs, strings.Join(terms, "\n"+strings.Repeat(" ", len(lhs)+1)+"+ "))
+	}
+}
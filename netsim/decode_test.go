@@ -0,0 +1,102 @@
+package netsim
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+// strobes are what the emulator would show for a (non-IPL) control word.
+func strobes(word uint32) (s OWL.Strobes) {
+	for _, it := range []struct {
+		bits uint32
+		s    OWL.Strobes
+	}{
+		{T_LOAD_N, OWL.LatchT}, {PC_LOAD_N, OWL.LoadPC},
+		{LD_A_N | CNT_A_N, OWL.LatchA}, {LD_B_N | CNT_W_N, OWL.LatchB}, {LD_H_N | CNT_W_N, OWL.LatchH}, {LD_L_N | CNT_W_N, OWL.LatchL},
+		{RAM_WE, OWL.WriteM}, {WR_E, OWL.WriteE}, {WR_F, OWL.WriteF}, {WR_G, OWL.WriteG},
+		{RD_E_N, OWL.ReadE}, {RD_F_N, OWL.ReadF}, {RD_G_N, OWL.ReadG},
+	} {
+		if (word^activeLow)&it.bits != 0 {
+			s |= it.s
+		}
+	}
+	// Reading the opcode or the immediate byte, at the PC, is not a read of M.
+	if asserted(word, RAM_OE_N) && !asserted(word, ADDR_PC_N) {
+		s |= OWL.ReadM
+	}
+	return
+}
+
+// TestDecodeMatchesEmulator checks the decode ROMs against the emulator's DecodeControls.
+func TestDecodeMatchesEmulator(t *testing.T) {
+	for op := 0; op < 256; op++ {
+		for _, execute := range []bool{false, true} {
+			for _, a := range []byte{0, 1} {
+				got := strobes(DecodeWord(byte(op), execute, a != 0, false))
+				want := OWL.DecodeControls(byte(op), execute, a)
+				if op == 0x64 && execute { // mv m,m writes nothing on the hardware
+					want &^= OWL.WriteM
+				}
+				if got != want {
+					t.Errorf("opcode %02x execute=%v a=%d: ROM gives %v, emulator %v", op, execute, a, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestBusUsed checks that the lockstep compares the data bus
+// in just the cycles where the decode ROMs drive it.
+func TestBusUsed(t *testing.T) {
+	const drivers = RAM_OE_N | DRV_A_N | DRV_B_N | DRV_H_N | DRV_L_N | RD_E_N | RD_F_N | RD_G_N | IPL_DRV_N
+	for op := 0; op < 256; op++ {
+		for _, execute := range []bool{false, true} {
+			want := (DecodeWord(byte(op), execute, false, false)^activeLow)&drivers != 0
+			if got := busUsed(vmCycle{Cycle: OWL.Cycle{Execute: execute}, t: byte(op)}); got != want {
+				t.Errorf("opcode %02x execute=%v: busUsed is %v, want %v", op, execute, got, want)
+			}
+		}
+	}
+}
+
+// TestMinimize checks the equations give the same control words as the ROMs.
+func TestMinimize(t *testing.T) {
+	for i, name := range ControlNames {
+		bit := uint32(1) << i
+		var minterms []uint
+		for addr := uint(0); addr < 2048; addr++ {
+			if asserted(DecodeROMWord(addr), bit) {
+				minterms = append(minterms, addr)
+			}
+		}
+		cover := minimize(minterms, len(romInputs))
+		for addr := uint(0); addr < 2048; addr++ {
+			got := false
+			for _, c := range cover {
+				got = got || c.covers(addr)
+			}
+			if want := asserted(DecodeROMWord(addr), bit); got != want {
+				t.Fatalf("%s at address %03x: equation gives %v, ROM %v", name, addr, got, want)
+			}
+		}
+	}
+}
+
+// TestROMImages checks the images in hardware/ are up to date;
+// run "go run owl-decode/owl-decode.go -o hardware" if not.
+func TestROMImages(t *testing.T) {
+	for lane := 0; lane < 4; lane++ {
+		filename := fmt.Sprintf("../hardware/decode%d.bin", lane)
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, DecodeROM(lane)) {
+			t.Errorf("%s is out of date", filename)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package netsim
+
+import (
+	"fmt"
+	"strings"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+// vmCycle is a cycle of the Vm, and its registers after the cycle.
+type vmCycle struct {
+	OWL.Cycle
+	t          byte
+	a, b, h, l byte
+	pc         uint
+}
+
+// Mismatch is the first cycle where the circuit and the Vm disagree.
+type Mismatch struct {
+	Cycle OWL.Cycle // as the Vm did it
+	T     byte      // the opcode
+	What  string
+}
+
+func (m *Mismatch) Error() string {
+	phase := "FETCH"
+	if m.Cycle.Execute {
+		phase = "EXECUTE"
+	}
+	if m.Cycle.IPL {
+		phase = "IPL " + phase
+	}
+	text, _ := OWL.Disasm(m.T, 0)
+	text, _, _ = strings.Cut(text, " $") // the immediate byte is not known here
+	return fmt.Sprintf("cycle %d (%s of %s, address $%06x): %s", m.Cycle.Num, phase, text, m.Cycle.Addr, m.What)
+}
+
+// Lockstep runs a Vm and a Sim together, comparing them every cycle:
+// the address bus, the data bus (when it carries something),
+// what is written to RAM and to the ports, and then the registers.
+type Lockstep struct {
+	Vm      *OWL.Vm
+	Sim     *Sim
+	pending []vmCycle
+}
+
+// NewLockstep sets vm.OnCycle, to compare the Vm with the Sim.
+// The Sim needs a pico (for IPL), an sram, and ports for the Vm's ports.
+func NewLockstep(vm *OWL.Vm, sim *Sim) (*Lockstep, error) {
+	if sim.Pico == nil || sim.RAM == nil {
+		return nil, fmt.Errorf("the netlist needs a pico and an sram")
+	}
+	ls := &Lockstep{Vm: vm, Sim: sim}
+	vm.OnCycle = func(c *OWL.Cycle) {
+		ls.pending = append(ls.pending, vmCycle{Cycle: *c, t: vm.T(), a: vm.A(), b: vm.B(), h: vm.H(), l: vm.L(), pc: vm.PC()})
+	}
+	return ls, nil
+}
+
+// IPL loads the program into both.
+func (ls *Lockstep) IPL(vec []byte) error {
+	ls.Vm.IPL(vec)
+	ls.Sim.Pico.IPL = true
+	defer func() { ls.Sim.Pico.IPL = false }()
+	for i, c := range ls.pending {
+		ls.Sim.Pico.Data = vec[i]
+		if err := ls.cycle(c); err != nil {
+			ls.pending = nil
+			return err
+		}
+	}
+	ls.pending = nil
+	return nil
+}
+
+// Steps executes up to n steps in both.  It returns false if the Vm stopped.
+// The error is a *Mismatch when they disagree.
+func (ls *Lockstep) Steps(n int) (bool, error) {
+	for i := 0; i < n; i++ {
+		ok := ls.Vm.Steps(1)
+		for _, c := range ls.pending {
+			if err := ls.cycle(c); err != nil {
+				ls.pending = nil
+				return false, err
+			}
+		}
+		ls.pending = nil
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// busUsed tells if the data bus carries a value in the cycle.
+func busUsed(c vmCycle) bool {
+	if !c.Execute {
+		return true
+	}
+	op := OWL.Opcodes[c.t]
+	if op == nil {
+		return false
+	}
+	switch op.Mnemonic {
+	case "mv", "ld", "st", "set":
+		return true
+	}
+	return false // INCA, BNZ, and the like
+}
+
+var portReads = map[string]OWL.Strobes{"E": OWL.ReadE, "F": OWL.ReadF, "G": OWL.ReadG}
+var portWrites = map[string]OWL.Strobes{"E": OWL.WriteE, "F": OWL.WriteF, "G": OWL.WriteG}
+
+// cycle simulates one cycle of the circuit, and compares it with the Vm's.
+func (ls *Lockstep) cycle(c vmCycle) error {
+	s := ls.Sim
+	mismatch := func(format string, args ...any) error {
+		return &Mismatch{Cycle: c.Cycle, T: c.t, What: fmt.Sprintf(format, args...)}
+	}
+
+	for name, port := range s.Ports {
+		port.Wrote = false
+		if c.Strobes&portReads[name] != 0 {
+			port.Input = c.Data
+		}
+	}
+	if err := s.Settle(); err != nil {
+		return mismatch("%v", err)
+	}
+	if err := s.Fall(); err != nil {
+		return mismatch("%v", err)
+	}
+
+	addr, err := s.Bus("ADDR", 24)
+	if err != nil {
+		return mismatch("address bus: %v", err)
+	}
+	if addr != c.Addr {
+		return mismatch("address bus is $%06x, but the emulator has $%06x", addr, c.Addr)
+	}
+	if busUsed(c) {
+		data, err := s.Bus("D", 8)
+		if err != nil {
+			return mismatch("data bus: %v", err)
+		}
+		if byte(data) != c.Data {
+			return mismatch("data bus is $%02x, but the emulator has $%02x", data, c.Data)
+		}
+	}
+	for name, port := range s.Ports {
+		vmWrote := c.Strobes&portWrites[name] != 0
+		switch {
+		case port.Wrote && !vmWrote:
+			return mismatch("port %s was written with $%02x, but not by the emulator", name, port.Output)
+		case !port.Wrote && vmWrote:
+			return mismatch("port %s was not written, but the emulator wrote $%02x", name, c.Data)
+		case vmWrote && port.Output != c.Data:
+			return mismatch("port %s was written with $%02x, but the emulator wrote $%02x", name, port.Output, c.Data)
+		}
+	}
+	if c.Strobes&OWL.WriteM != 0 {
+		if got := s.RAM.Peek(c.Addr); got != c.Data {
+			return mismatch("RAM at $%06x is $%02x, but the emulator wrote $%02x", c.Addr, got, c.Data)
+		}
+	}
+
+	if err := s.Rise(); err != nil {
+		return mismatch("at the end of the cycle: %v", err)
+	}
+	for _, r := range []struct {
+		name  string
+		width int
+		want  uint
+	}{
+		{"T", 8, uint(c.t)}, {"A", 8, uint(c.a)}, {"B", 8, uint(c.b)}, {"H", 8, uint(c.h)}, {"L", 8, uint(c.l)}, {"PC", 24, c.pc},
+	} {
+		got, err := s.Bus(r.name, r.width)
+		if err != nil {
+			return mismatch("register %s: %v", r.name, err)
+		}
+		if got != r.want {
+			return mismatch("register %s is $%x afterwards, but the emulator has $%x", r.name, got, r.want)
+		}
+	}
+	return nil
+}
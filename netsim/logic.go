@@ -0,0 +1,105 @@
+package netsim
+
+import (
+	"sort"
+)
+
+// A cube is a product term over the inputs: the inputs set in care
+// must have the values in val; the others do not matter.
+type cube struct {
+	val, care uint
+}
+
+// covers tells if the cube includes the minterm.
+func (c cube) covers(m uint) bool {
+	return m&c.care == c.val
+}
+
+// minimize finds a small sum of products that is true for the minterms
+// and false for all other inputs (there are no don't-cares).
+// It uses Quine-McCluskey to find the prime implicants,
+// then covers the minterms with the essential primes,
+// and then greedily with the primes covering the most.
+func minimize(minterms []uint, nvars int) []cube {
+	all := uint(1)<<nvars - 1
+	level := make(map[cube]bool)
+	for _, m := range minterms {
+		level[cube{m, all}] = true
+	}
+	var primes []cube
+	for len(level) > 0 {
+		next := make(map[cube]bool)
+		merged := make(map[cube]bool)
+		for c := range level {
+			for bit := uint(1); bit <= all; bit <<= 1 {
+				if c.care&bit == 0 || c.val&bit != 0 {
+					continue
+				}
+				other := cube{c.val | bit, c.care}
+				if level[other] {
+					next[cube{c.val, c.care &^ bit}] = true
+					merged[c], merged[other] = true, true
+				}
+			}
+		}
+		for c := range level {
+			if !merged[c] {
+				primes = append(primes, c)
+			}
+		}
+		level = next
+	}
+	sort.Slice(primes, func(i, j int) bool {
+		if primes[i].care != primes[j].care {
+			return primes[i].care < primes[j].care
+		}
+		return primes[i].val < primes[j].val
+	})
+
+	uncovered := make(map[uint]bool)
+	for _, m := range minterms {
+		uncovered[m] = true
+	}
+	var cover []cube
+	take := func(p cube) {
+		cover = append(cover, p)
+		for m := range uncovered {
+			if p.covers(m) {
+				delete(uncovered, m)
+			}
+		}
+	}
+	// Essential primes: the only prime covering some minterm.
+	for _, m := range minterms {
+		if !uncovered[m] {
+			continue
+		}
+		var only *cube
+		count := 0
+		for i := range primes {
+			if primes[i].covers(m) {
+				only = &primes[i]
+				count++
+			}
+		}
+		if count == 1 {
+			take(*only)
+		}
+	}
+	for len(uncovered) > 0 {
+		best, bestCount := cube{}, -1
+		for _, p := range primes {
+			count := 0
+			for m := range uncovered {
+				if p.covers(m) {
+					count++
+				}
+			}
+			if count > bestCount {
+				best, bestCount = p, count
+			}
+		}
+		take(best)
+	}
+	return cover
+}
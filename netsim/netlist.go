@@ -0,0 +1,64 @@
+// Package netsim simulates the ABhL hardware from a netlist of 74-series
+// parts, using behavioral models of the chips, and runs it in lockstep
+// with the emulator (ABhL.Vm) to find where the two disagree.
+//
+// A netlist is JSON, listing the parts, their types, and which net
+// each pin connects to.  Pins use the names on the datasheets,
+// with "/" for active low, like "/OE":
+//
+//	{"parts": [
+//	  {"ref": "U4", "type": "74377", "pins": {"D0": "D0", "Q0": "T0", "/E": "T_LOAD_N", "CP": "CLK", ...}},
+//	  {"ref": "U5", "type": "2716", "attrs": {"image": "decode0.bin"}, "pins": {...}}
+//	]}
+//
+// The nets VCC and GND are constant, and the simulator drives CLK.
+// The lockstep harness reads the buses by net name (ADDR0-ADDR23,
+// D0-D7) and the registers (A0-A7, B0-B7, H0-H7, L0-L7, PC0-PC23, T0-T7).
+package netsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Netlist lists the parts of a circuit and how they connect.
+type Netlist struct {
+	Parts []PartSpec `json:"parts"`
+	Dir   string     `json:"-"` // directory for files named in attrs, like ROM images
+}
+
+// PartSpec is one part in a Netlist.
+type PartSpec struct {
+	Ref   string            `json:"ref"`   // reference designator, like "U4"
+	Type  string            `json:"type"`  // model, like "74377"
+	Pins  map[string]string `json:"pins"`  // pin name to net name
+	Attrs map[string]string `json:"attrs"` // extra settings for the model
+}
+
+// ParseNetlist reads a JSON netlist.  Files it names are relative to dir.
+func ParseNetlist(r io.Reader, dir string) (*Netlist, error) {
+	nl := &Netlist{Dir: dir}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(nl); err != nil {
+		return nil, err
+	}
+	return nl, nil
+}
+
+// LoadNetlist reads a JSON netlist file.
+func LoadNetlist(filename string) (*Netlist, error) {
+	r, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	nl, err := ParseNetlist(r, filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("netlist %q: %v", filename, err)
+	}
+	return nl, nil
+}
@@ -0,0 +1,114 @@
+package netsim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+func quiet(format string, args ...any) {}
+
+func assemble(filename string) []byte {
+	return OWL.CreateIPL(OWL.AssembleFiles([]string{filename}, nil))
+}
+
+// everything uses every kind of instruction, with carries and borrows through W.
+const everything = `
+	org $100
+start	setb $01
+	seth $ff
+	setl $ff
+	incw		; to $020000
+	mv l,a
+	sta 3
+	incw
+	decw
+	decw		; back to $01ffff
+	ldh 3
+	mv h,m
+	mv m,b
+	deca
+	deca
+	inca
+	mv b,m
+	stb 15
+	lda 15
+	seta 7
+	mv a,g
+`
+
+// run runs a program in lockstep with the reference netlist.
+func run(t *testing.T, nl *Netlist, ipl []byte, stdin string, args []string) (*OWL.Vm, *OWL.BufferPort, error) {
+	sim, err := NewSim(nl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := &OWL.Vm{Logf: quiet}
+	term := &OWL.BufferPort{Input: []byte(stdin)}
+	vm.F, vm.G = term, OWL.NewArgsExit(vm, args)
+	ls, err := NewLockstep(vm, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.IPL(ipl); err != nil {
+		return vm, term, err
+	}
+	_, err = ls.Steps(100000)
+	return vm, term, err
+}
+
+func TestLockstep(t *testing.T) {
+	nl, err := LoadNetlist("../hardware/abhl.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, term, err := run(t, nl, assemble("../tests/echo/echo.owl"), "hi\x00", nil)
+	if err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	if got := term.Output.String(); got != "hi\x00" || !vm.Stopped() {
+		t.Errorf("echo: output %q, stopped=%v", got, vm.Stopped())
+	}
+	if _, _, err := run(t, nl, assemble("../tests/args/args.owl"), "", []string{"one", "two"}); err != nil {
+		t.Fatalf("args: %v", err)
+	}
+	compact := OWL.CreateCompactIPL(OWL.AssembleFiles([]string{"../tests/echo/echo.owl"}, nil))
+	vm, term, err = run(t, nl, compact, "ok\x00", nil)
+	if err != nil {
+		t.Fatalf("compact echo: %v", err)
+	}
+	if got := term.Output.String(); got != "ok\x00" || !vm.Stopped() {
+		t.Errorf("compact echo: output %q, stopped=%v", got, vm.Stopped())
+	}
+	lines := strings.Split(everything, "\n")
+	vm, _, err = run(t, nl, OWL.CreateIPL(OWL.AssembleLines(lines, make([]string, len(lines)), nil)), "", nil)
+	if err != nil {
+		t.Fatalf("everything: %v", err)
+	}
+	if !vm.Stopped() || vm.W() != 0x0000ff || vm.A() != 7 {
+		t.Errorf("everything: stopped=%v W=%06x", vm.Stopped(), vm.W())
+	}
+}
+
+func TestLockstepFindsMistake(t *testing.T) {
+	nl, err := LoadNetlist("../hardware/abhl.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swap two wires from the data bus into the T latch.
+	for _, p := range nl.Parts {
+		if p.Type == "74377" {
+			p.Pins["D2"], p.Pins["D3"] = p.Pins["D3"], p.Pins["D2"]
+		}
+	}
+	_, _, err = run(t, nl, assemble("../tests/echo/echo.owl"), "hi\x00", nil)
+	var m *Mismatch
+	if !errors.As(err, &m) {
+		t.Fatalf("got %v, want a Mismatch", err)
+	}
+	if m.Cycle.Num != 0 || !strings.Contains(m.What, "register T") {
+		t.Errorf("got %v, want register T to differ at cycle 0", err)
+	}
+}
@@ -0,0 +1,233 @@
+package netsim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type level byte
+
+const (
+	low level = iota
+	high
+	floating // no driver
+	conflict // driven both high and low
+)
+
+// part is the model of one chip.
+type part interface {
+	// eval drives the outputs, from the inputs and the state.
+	eval(s *Sim)
+	// edge samples the inputs, if the clock rose since the last call,
+	// and prepares the next state.
+	edge(s *Sim)
+	// commit changes to the state prepared by edge.
+	commit()
+}
+
+// Sim is a circuit built from a Netlist.
+type Sim struct {
+	names  []string
+	index  map[string]int
+	values []level
+	drive0 []int // during eval, how many parts drive each net low
+	drive1 []int // and high
+	parts  []part
+	refs   []string // reference designators of parts
+	vcc    int
+	gnd    int
+	clk    int
+	clock  bool
+
+	Ports map[string]*Port // ports E, F, and G, by name
+	Pico  *Pico            // the IPL device
+	RAM   *SRAM
+}
+
+// maxSettle limits the rounds of evaluation before the circuit must be stable.
+const maxSettle = 1000
+
+// NewSim builds a circuit from a netlist.  The clock starts high,
+// and the registers start at zero.
+func NewSim(nl *Netlist) (*Sim, error) {
+	s := &Sim{index: make(map[string]int), Ports: make(map[string]*Port), clock: true}
+	s.vcc, s.gnd, s.clk = s.net("VCC"), s.net("GND"), s.net("CLK")
+	for _, spec := range nl.Parts {
+		m, ok := models[spec.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown part type %q", spec.Ref, spec.Type)
+		}
+		pp := &pins{ref: spec.Ref, typ: spec.Type, nets: make(map[string]int), attrs: spec.Attrs, dir: nl.Dir}
+		valid := make(map[string]bool)
+		for _, name := range m.pins {
+			valid[name] = true
+		}
+		for pin, net := range spec.Pins {
+			if !valid[pin] {
+				return nil, fmt.Errorf("%s (%s): no pin named %q", spec.Ref, spec.Type, pin)
+			}
+			pp.nets[pin] = s.net(net)
+		}
+		p, err := m.build(s, pp)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s): %v", spec.Ref, spec.Type, err)
+		}
+		s.parts = append(s.parts, p)
+		s.refs = append(s.refs, spec.Ref)
+	}
+	s.values = make([]level, len(s.names))
+	for i := range s.values {
+		s.values[i] = floating
+	}
+	s.drive0 = make([]int, len(s.names))
+	s.drive1 = make([]int, len(s.names))
+	if err := s.settle(); err != nil {
+		return nil, err
+	}
+	for _, p := range s.parts {
+		p.edge(s) // so sequential parts know the clock is high
+	}
+	return s, nil
+}
+
+// net returns the index of the net, creating it if new.
+func (s *Sim) net(name string) int {
+	if i, ok := s.index[name]; ok {
+		return i
+	}
+	s.index[name] = len(s.names)
+	s.names = append(s.names, name)
+	return len(s.names) - 1
+}
+
+// get reads a net as TTL does: a floating input reads high.
+// Unconnected pins are -1.
+func (s *Sim) get(n int) bool {
+	return n < 0 || s.values[n] != low
+}
+
+func (s *Sim) getBits(ns []int) (z uint) {
+	for i, n := range ns {
+		if s.get(n) {
+			z |= 1 << i
+		}
+	}
+	return
+}
+
+func (s *Sim) drive(n int, v bool) {
+	switch {
+	case n < 0:
+	case v:
+		s.drive1[n]++
+	default:
+		s.drive0[n]++
+	}
+}
+
+func (s *Sim) driveBits(ns []int, z uint) {
+	for i, n := range ns {
+		s.drive(n, z&(1<<i) != 0)
+	}
+}
+
+// settle evaluates the parts until no net changes.
+func (s *Sim) settle() error {
+	for round := 0; round < maxSettle; round++ {
+		for i := range s.values {
+			s.drive0[i], s.drive1[i] = 0, 0
+		}
+		s.drive(s.vcc, true)
+		s.drive(s.gnd, false)
+		s.drive(s.clk, s.clock)
+		for _, p := range s.parts {
+			p.eval(s)
+		}
+		changed := false
+		for i := range s.values {
+			v := floating
+			switch {
+			case s.drive0[i] > 0 && s.drive1[i] > 0:
+				v = conflict
+			case s.drive0[i] > 0:
+				v = low
+			case s.drive1[i] > 0:
+				v = high
+			}
+			if v != s.values[i] {
+				s.values[i] = v
+				changed = true
+			}
+		}
+		if !changed {
+			var fights []string
+			for i, v := range s.values {
+				if v == conflict {
+					fights = append(fights, s.names[i])
+				}
+			}
+			if len(fights) > 0 {
+				sort.Strings(fights)
+				return fmt.Errorf("bus contention on %s", strings.Join(fights, " "))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("circuit does not settle after %d rounds (is it oscillating?)", maxSettle)
+}
+
+// Settle propagates changes to inputs, like the Pico's or the ports'.
+func (s *Sim) Settle() error {
+	return s.settle()
+}
+
+// Fall makes the clock fall, in the middle of a cycle.
+func (s *Sim) Fall() error {
+	s.clock = false
+	if err := s.settle(); err != nil {
+		return err
+	}
+	for _, p := range s.parts {
+		p.edge(s) // only notes the clock is low
+	}
+	return nil
+}
+
+// Rise makes the clock rise, at the end of a cycle:
+// the gated strobes end, then the registers latch, then the circuit settles.
+func (s *Sim) Rise() error {
+	s.clock = true
+	if err := s.settle(); err != nil {
+		return err
+	}
+	for _, p := range s.parts {
+		p.edge(s)
+	}
+	for _, p := range s.parts {
+		p.commit()
+	}
+	return s.settle()
+}
+
+// Bus reads the nets prefix0, prefix1, ... as a number, least significant first.
+// It fails if any net is missing, floating, or in contention.
+func (s *Sim) Bus(prefix string, width int) (uint, error) {
+	var z uint
+	for i := 0; i < width; i++ {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		n, ok := s.index[name]
+		if !ok {
+			return 0, fmt.Errorf("no net %s", name)
+		}
+		switch s.values[n] {
+		case high:
+			z |= 1 << i
+		case floating:
+			return 0, fmt.Errorf("net %s is floating", name)
+		case conflict:
+			return 0, fmt.Errorf("net %s is in contention", name)
+		}
+	}
+	return z, nil
+}
@@ -0,0 +1,506 @@
+package ABhL // pronounced "owl"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+// Object is a relocatable object file, made by owl-asm -c, for owl-ld.
+//
+// The rows before the first ORG are relocatable: their addresses start
+// at 0, and the linker places them where the previous object ended,
+// just as if the sources had been assembled together.
+// Rows after an ORG are absolute.
+//
+// Labels used in the object that defines them are relocated by where
+// it is placed, and other labels are imported.  The linker fills in
+// both, through the Relocs.
+type Object struct {
+	Sources []string
+
+	Reloc []Segment // bytes at relocatable addresses
+	Abs   []Segment // bytes at absolute addresses
+
+	// End is the address after the last row.  EndReloc tells if
+	// it is relocatable (if there is no ORG).
+	End      uint
+	EndReloc bool
+
+	Symbols []ObjSymbol
+	Imports []string // labels used but not defined
+	Tables  []ObjTable
+	Relocs  []ObjReloc
+
+	Overlays []ObjOverlay // bytes generated under OVERLAY
+}
+
+// ObjSymbol is a label defined in an Object.
+type ObjSymbol struct {
+	Name  string
+	Kind  string // as in Symbol: "addr", "equ", "bank", or "row"
+	Value uint
+	Reloc bool // Value is relocatable (or relative to Import)
+
+	// An EQU may be relative to an imported label,
+	// and may be a field (as in ObjReloc) of that.
+	Import string
+	Field  string
+}
+
+// ObjTable is a BANK or ROW table, for the linker to allocate.
+// The linker numbers banks and rows in order across all objects.
+type ObjTable struct {
+	Kind    string // "bank" or "row"
+	Rows    uint   // how many rows, for "row"
+	Data    []byte // by H and L; nil for a BANK with no argument
+	Where   string
+	Overlay bool // generated under OVERLAY
+}
+
+// ObjReloc is a byte or word for the linker to fill in, with the
+// Field of the address of the Symbol plus the Addend.
+type ObjReloc struct {
+	Addr   uint
+	Reloc  bool   // Addr is relocatable
+	Field  string // "b", "h", or "l", for a byte; "hl" for a word (H first)
+	Symbol string // an imported label, or "" for where the object is placed
+	Addend uint
+	Where  string
+}
+
+// relocTerm is what the linker fills in, for a value in an object:
+// it is relative to the address of a label, or is a field of that.
+type relocTerm struct {
+	symbol string // an imported label, or "" for where the object is placed
+	field  string // "", or the field: "b", "h", "l", or "hl"
+	addend uint   // with a field, the value it is a field of
+}
+
+// ObjOverlay is a range of bytes generated under OVERLAY,
+// which may overwrite bytes generated before them.
+type ObjOverlay struct {
+	Addr  uint
+	Size  uint
+	Reloc bool // Addr is relocatable
+}
+
+// AssembleObjectFiles assembles the source files into an Object,
+// writing the listing to listing, if it is not nil.
+func AssembleObjectFiles(filenames []string, listing io.Writer) *Object {
+	lines, wheres := SlurpSources(filenames)
+	obj := AssembleObject(lines, wheres, listing)
+	obj.Sources = filenames
+	return obj
+}
+
+// objectBuilder collects an Object as PassThree generates it.
+type objectBuilder struct {
+	obj                  *Object
+	relocRows            map[*Row]bool // rows before the first ORG
+	reloc                map[uint]byte
+	abs                  map[uint]byte
+	relocAddrs, absAddrs []uint
+
+	// for overlaps within the object; Link finds the others.
+	relocOwners, absOwners ownership
+}
+
+// AssembleObject assembles the lines into an Object,
+// where wheres names the source location of each line.
+// It panics with the Diagnostics, if there are errors.
+func AssembleObject(lines []string, wheres []string, listing io.Writer) *Object {
+	diags := &Diagnostics{}
+	obj := AssembleToObject(lines, wheres, listing, diags)
+	if diags.Errors() > 0 {
+		log.Panicf("%s", diags)
+	}
+	return obj
+}
+
+// AssembleToObject is AssembleObject, but collects errors and warnings
+// in diags.  If there are errors, it returns nil.
+func AssembleToObject(lines []string, wheres []string, listing io.Writer, diags *Diagnostics) *Object {
+	mod := ParseLines(lines, wheres)
+	mod.listing = listing
+	mod.reloc = true
+	mod.imports = make(map[string]bool)
+	mod.diags = diags
+	defer func() { mod.diags = nil }()
+	if !mod.runPasses(MacroPassOne, MacroPassTwo, PassOne, PassTwo) {
+		return nil
+	}
+
+	b := &objectBuilder{
+		obj:       &Object{EndReloc: true},
+		relocRows: make(map[*Row]bool),
+		reloc:     make(map[uint]byte),
+		abs:       make(map[uint]byte),
+	}
+	obj := b.obj
+	for _, row := range mod.rows {
+		switch row.opcode {
+		case "org":
+			obj.EndReloc = false
+			obj.End = row.addr
+		case "equ", "bank", "row", "assert":
+		default:
+			obj.End = row.addr + row.length
+		}
+		b.relocRows[row] = obj.EndReloc
+	}
+
+	mod.object = b
+	if !mod.runPasses(PassThree) {
+		return nil
+	}
+	obj.Reloc = segmentsOf(b.reloc, b.relocAddrs)
+	obj.Abs = segmentsOf(b.abs, b.absAddrs)
+
+	for _, row := range mod.rows {
+		if row.label == "" {
+			continue
+		}
+		lab := mod.labels[row.label]
+		sym := ObjSymbol{Name: row.label, Kind: "addr", Value: lab.addr, Reloc: lab.reloc}
+		switch row.opcode {
+		case "equ", "bank", "row":
+			sym.Kind = row.opcode
+		}
+		if lab.reloc {
+			sym.Import, sym.Field = lab.rel.symbol, lab.rel.field
+			if sym.Field != "" {
+				sym.Value = lab.rel.addend
+			}
+		}
+		obj.Symbols = append(obj.Symbols, sym)
+	}
+	for name := range mod.imports {
+		if _, ok := mod.labels[name]; !ok {
+			obj.Imports = append(obj.Imports, name)
+		}
+	}
+	sort.Strings(obj.Imports)
+	return obj
+}
+
+// generateReloc generates the row into the Object.
+// BANK and ROW tables are kept for the linker to allocate.
+func (mod *Mod) generateReloc(row *Row) {
+	b := mod.object
+	n := len(mod.generated)
+	row.instr.generate(mod, row)
+	gen := mod.generated[n:]
+	mod.generated = mod.generated[:n]
+
+	switch row.opcode {
+	case "bank", "row":
+		table := ObjTable{Kind: row.opcode, Where: row.where, Overlay: mod.overlay != nil}
+		base := row.addr << 16
+		if row.opcode == "row" {
+			table.Rows = 1
+			if len(row.args) >= 2 {
+				table.Rows = mod.EvalArg(row, 1)
+			}
+			base = (mod.rowsBank << 16) + (row.addr << 8)
+		}
+		if len(gen) > 0 {
+			table.Data = make([]byte, len(gen))
+			for _, pair := range gen {
+				table.Data[pair.addr-base] = pair.data
+			}
+		}
+		b.obj.Tables = append(b.obj.Tables, table)
+		return
+	}
+
+	reloc := b.relocRows[row]
+	for _, pair := range gen {
+		owners := &b.absOwners
+		if reloc {
+			owners = &b.relocOwners
+		}
+		if previous := owners.claim(pair.addr, whereOf(row)); previous != "" && mod.overlay == nil {
+			mod.errorf(row, "", "%s", overlapMessage(pair.addr, previous))
+		}
+		if mod.overlay != nil {
+			b.overlaid(pair.addr, reloc)
+		}
+		if reloc {
+			if _, ok := b.reloc[pair.addr]; !ok {
+				b.relocAddrs = append(b.relocAddrs, pair.addr)
+			}
+			b.reloc[pair.addr] = pair.data
+		} else {
+			if _, ok := b.abs[pair.addr]; !ok {
+				b.absAddrs = append(b.absAddrs, pair.addr)
+			}
+			b.abs[pair.addr] = pair.data
+		}
+	}
+}
+
+// relocate records that the linker fills in the size bytes at addr,
+// generated by the row from value, which rel relocates.
+// A byte gets L of a relocated address, and a word gets HL;
+// a field that is only a byte goes in the low byte of a word.
+func (b *objectBuilder) relocate(row *Row, addr uint, size uint, rel relocTerm, value uint) {
+	r := ObjReloc{Addr: addr, Reloc: b.relocRows[row], Field: rel.field, Symbol: rel.symbol, Addend: rel.addend, Where: row.where}
+	if r.Field == "" {
+		r.Field, r.Addend = "hl", value
+	}
+	if size == 1 && r.Field == "hl" {
+		r.Field = "l"
+	}
+	if size == 2 && r.Field != "hl" {
+		r.Addr++ // the high byte is 0
+	}
+	b.obj.Relocs = append(b.obj.Relocs, r)
+}
+
+// overlaid extends the Overlays with the byte at addr.
+func (b *objectBuilder) overlaid(addr uint, reloc bool) {
+	if n := len(b.obj.Overlays); n > 0 {
+		last := &b.obj.Overlays[n-1]
+		if last.Reloc == reloc && last.Addr+last.Size == addr {
+			last.Size++
+			return
+		}
+	}
+	b.obj.Overlays = append(b.obj.Overlays, ObjOverlay{Addr: addr, Size: 1, Reloc: reloc})
+}
+
+// WriteObject writes the Object to the file, as JSON.
+func WriteObject(obj *Object, filename string) {
+	bb, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		log.Panicf("Cannot encode object: %v", err)
+	}
+	err = ioutil.WriteFile(filename, append(bb, '\n'), 0644)
+	if err != nil {
+		log.Panicf("Error writing object file %q: %v", filename, err)
+	}
+}
+
+// ReadObject reads an object file written by WriteObject.
+func ReadObject(filename string) (*Object, error) {
+	bb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{}
+	if err := json.Unmarshal(bb, obj); err != nil {
+		return nil, fmt.Errorf("bad object file %q: %v", filename, err)
+	}
+	return obj, nil
+}
+
+// Link places the objects in order, allocates their BANK and ROW
+// tables, resolves their symbols, and fills in their Relocs,
+// into a Mod that can be written like an assembled one
+// (by WriteImage or WriteSymbols).  names name the objects
+// in error messages.  Bytes from different objects or tables
+// may not overlap, unless generated under OVERLAY.
+// A symbol defined by more than one object is a warning,
+// in diags if it is not nil, and other objects get the last one.
+func Link(objs []*Object, names []string, diags *Diagnostics) (*Mod, error) {
+	mod := &Mod{
+		labels: make(map[string]*Label),
+		macros: make(map[string]*Macro),
+	}
+	var owners ownership
+	gen := func(addr uint, data byte, where string, overlay bool) error {
+		if previous := owners.claim(addr, where); previous != "" && !overlay {
+			return fmt.Errorf("%s: %s", where, overlapMessage(addr, previous))
+		}
+		mod.generated = append(mod.generated, AddrData{addr, data})
+		return nil
+	}
+
+	// Place the objects, one after another.
+	bases := make([]uint, len(objs))
+	counter := uint(0)
+	for i, obj := range objs {
+		bases[i] = counter
+		counter = obj.End
+		if obj.EndReloc {
+			counter += bases[i]
+		}
+	}
+
+	// Find the symbols.  As when assembling, a later definition wins.
+	type definition struct {
+		sym ObjSymbol
+		obj int
+	}
+	defs := make(map[string]definition)
+	for i, obj := range objs {
+		for _, sym := range obj.Symbols {
+			if first, ok := defs[sym.Name]; ok && first.obj != i && diags != nil {
+				diags.List = append(diags.List, Diagnostic{
+					Severity: SevWarning,
+					File:     names[i],
+					Message:  fmt.Sprintf("symbol %q is also defined by %s; other objects get this one", sym.Name, names[first.obj]),
+				})
+			}
+			defs[sym.Name] = definition{sym, i}
+		}
+	}
+	for i, obj := range objs {
+		for _, name := range obj.Imports {
+			if _, ok := defs[name]; !ok {
+				return nil, fmt.Errorf("undefined symbol %q, used by %s", name, names[i])
+			}
+		}
+	}
+
+	// relocated is the field of the address of the symbol
+	// (or where object i is placed) plus the addend.
+	values := make(map[string]uint)
+	resolving := make(map[string]bool)
+	var valueOf func(name string) (uint, error)
+	relocated := func(i int, symbol string, fieldName string, addend uint) (uint, error) {
+		base := bases[i]
+		if symbol != "" {
+			var err error
+			if base, err = valueOf(symbol); err != nil {
+				return 0, err
+			}
+		}
+		x := 0xFFFFFF & (base + addend)
+		if fieldName != "" {
+			x = field(fieldName, x)
+		}
+		return x, nil
+	}
+	valueOf = func(name string) (uint, error) {
+		if value, ok := values[name]; ok {
+			return value, nil
+		}
+		def := defs[name]
+		if resolving[name] {
+			return 0, fmt.Errorf("symbol %q, defined by %s, depends on itself", name, names[def.obj])
+		}
+		resolving[name] = true
+		value := def.sym.Value
+		if def.sym.Reloc {
+			var err error
+			if value, err = relocated(def.obj, def.sym.Import, def.sym.Field, value); err != nil {
+				return 0, err
+			}
+		}
+		values[name] = value
+		return value, nil
+	}
+	for _, obj := range objs {
+		for _, sym := range obj.Symbols {
+			value, err := valueOf(sym.Name)
+			if err != nil {
+				return nil, err
+			}
+			mod.labels[sym.Name] = &Label{addr: value}
+			opcode := sym.Kind
+			if opcode == "addr" {
+				opcode = ""
+			}
+			mod.rows = append(mod.rows, &Row{label: sym.Name, opcode: opcode})
+		}
+	}
+
+	// Generate the bytes of the objects, with the Relocs filled in.
+	// A filled-in byte names its row, in errors.
+	type location struct {
+		reloc bool
+		addr  uint
+	}
+	for i, obj := range objs {
+		filled := make(map[location]byte)
+		wheres := make(map[location]string)
+		for _, r := range obj.Relocs {
+			x, err := relocated(i, r.Symbol, r.Field, r.Addend)
+			if err != nil {
+				return nil, err
+			}
+			at := location{r.Reloc, r.Addr}
+			if r.Field == "hl" {
+				filled[at], wheres[at] = byte(x>>8), r.Where
+				at.addr++
+			}
+			filled[at], wheres[at] = byte(x), r.Where
+		}
+		overlaid := make(map[uint]bool)
+		for _, o := range obj.Overlays {
+			for j := uint(0); j < o.Size; j++ {
+				if o.Reloc {
+					overlaid[bases[i]+o.Addr+j] = true
+				} else {
+					overlaid[o.Addr+j] = true
+				}
+			}
+		}
+		for _, part := range []struct {
+			segs  []Segment
+			reloc bool
+		}{{obj.Reloc, true}, {obj.Abs, false}} {
+			for _, seg := range part.segs {
+				for j, data := range seg.Data {
+					at := location{part.reloc, seg.Addr + uint(j)}
+					where := names[i]
+					if x, ok := filled[at]; ok {
+						data, where = x, wheres[at]
+					}
+					addr := at.addr
+					if part.reloc {
+						addr += bases[i]
+					}
+					if err := gen(addr, data, where, overlaid[addr]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	// Allocate the banks and rows, in order, as PassTwo would.
+	type placement struct {
+		table ObjTable
+		num   uint
+	}
+	var placed []placement
+	for _, obj := range objs {
+		for _, table := range obj.Tables {
+			switch table.Kind {
+			case "bank":
+				mod.currentBank++
+				if mod.currentBank > 15 { // assuming 1MB RAM
+					return nil, fmt.Errorf("too many BANK tables allocated, at %s", table.Where)
+				}
+				if table.Data == nil && mod.rowsBank == 0 {
+					mod.rowsBank = mod.currentBank
+				}
+				placed = append(placed, placement{table, mod.currentBank})
+			case "row":
+				mod.currentRow += table.Rows
+				if mod.currentRow > 255 {
+					return nil, fmt.Errorf("too many ROW tables allocated, at %s", table.Where)
+				}
+				placed = append(placed, placement{table, mod.currentRow - table.Rows})
+			}
+		}
+	}
+	for _, p := range placed {
+		base := p.num << 16
+		if p.table.Kind == "row" {
+			base = (mod.rowsBank << 16) + (p.num << 8)
+		}
+		for j, data := range p.table.Data {
+			if err := gen(base+uint(j), data, p.table.Where, p.table.Overlay); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return mod, nil
+}
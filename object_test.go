@@ -0,0 +1,155 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Three modules that use each other's labels.
+var linkSources = []string{`
+RowsBank BANK
+start	setb B(greeting)
+	seth H(greeting)
+	setl L(greeting)
+	mv m,a
+	fcw HL(table)
+	seta count
+	jump	loop
+`, `
+greeting	fcb 'h'
+	fcb 'i'
+tail	rmb 3
+count	equ tail-greeting
+loop	setb B(start)
+	setl L(loop)
+	assert loop > greeting
+NegRow	ROW (0-_L_)
+	org $2000
+fixed	fcb L(greeting)
+`, `
+table	fcb 1
+Twice	ROW (_L_+_L_), 2
+Adds	BANK (_H_+_L_)
+`}
+
+// jump is a macro, which each module must have to use.
+const jumpMacro = `
+jump	macro _x_
+	setl l(_x_)
+	endmacro
+`
+
+func sourceLines(name string, text string) (lines []string, wheres []string) {
+	lines = strings.Split(text, "\n")
+	for i := range lines {
+		wheres = append(wheres, fmt.Sprintf("%s:%d", name, i+1))
+	}
+	return
+}
+
+// TestLinkMatchesAssembly checks that linking objects gives the same
+// memory and symbols as assembling their sources together.
+func TestLinkMatchesAssembly(t *testing.T) {
+	var all, allWheres []string
+	var objs []*Object
+	var names []string
+	for i, text := range linkSources {
+		name := fmt.Sprintf("mod%d", i)
+		lines, wheres := sourceLines(name, text+jumpMacro)
+		all, allWheres = append(all, lines...), append(allWheres, wheres...)
+		objs = append(objs, AssembleObject(lines, wheres, nil))
+		names = append(names, name)
+	}
+	want := AssembleLines(all, allWheres, nil)
+	diags := &Diagnostics{}
+	got, err := Link(objs, names, diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags.List) > 0 {
+		t.Errorf("warnings: %s", diags)
+	}
+
+	if len(objs[0].Relocs) == 0 || len(objs[0].Imports) == 0 || len(objs[2].Tables) != 2 {
+		t.Errorf("expected relocs, imports, and tables, got %#v", objs[0])
+	}
+	if g, w := fmt.Sprintf("%x", ModSegments(got)), fmt.Sprintf("%x", ModSegments(want)); g != w {
+		t.Errorf("linked memory\n%s\nwant\n%s", g, w)
+	}
+	if g, w := fmt.Sprint(ModSymbols(got)), fmt.Sprint(ModSymbols(want)); g != w {
+		t.Errorf("linked symbols\n%s\nwant\n%s", g, w)
+	}
+	if addr, _ := got.LabelAddr("count"); addr != 2 {
+		t.Errorf("count is %d, want 2", addr)
+	}
+}
+
+func TestLinkUndefined(t *testing.T) {
+	lines, wheres := sourceLines("lonely", "\tsetl L(nowhere)\n")
+	obj := AssembleObject(lines, wheres, nil)
+	_, err := Link([]*Object{obj}, []string{"lonely.obj"}, nil)
+	if err == nil || err.Error() != `undefined symbol "nowhere", used by lonely.obj` {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestObjectRelocs(t *testing.T) {
+	lines, wheres := sourceLines("rel", "here\tfcw HL(far+2)\n\tseta here+1\n\tfcw B(far)\nnext\tequ far+1\nlow\tequ L(next)\n")
+	obj := AssembleObject(lines, wheres, nil)
+	want := "[{Addr:0 Reloc:true Field:hl Symbol:far Addend:2 Where:rel:1} " +
+		"{Addr:3 Reloc:true Field:l Symbol: Addend:1 Where:rel:2} " +
+		"{Addr:5 Reloc:true Field:b Symbol:far Addend:0 Where:rel:3}]"
+	if got := fmt.Sprintf("%+v", obj.Relocs); got != want {
+		t.Errorf("relocs\n%s\nwant\n%s", got, want)
+	}
+	want = "[{Name:here Kind:addr Value:0 Reloc:true Import: Field:} " +
+		"{Name:next Kind:equ Value:1 Reloc:true Import:far Field:} " +
+		"{Name:low Kind:equ Value:1 Reloc:true Import:far Field:l}]"
+	if got := fmt.Sprintf("%+v", obj.Symbols); got != want {
+		t.Errorf("symbols\n%s\nwant\n%s", got, want)
+	}
+
+	for _, bad := range []string{"L(far)+1", "far*2", "far-here", "-here"} {
+		lines, wheres := sourceLines("bad", "here\tfcb "+bad+"\n")
+		diags := &Diagnostics{}
+		AssembleToObject(lines, wheres, nil, diags)
+		if !strings.Contains(diags.String(), "cannot relocate") {
+			t.Errorf("%s: got %s", bad, diags)
+		}
+	}
+}
+
+func linkTexts(t *testing.T, diags *Diagnostics, texts ...string) (*Mod, error) {
+	var objs []*Object
+	var names []string
+	for i, text := range texts {
+		name := fmt.Sprintf("mod%d", i)
+		lines, wheres := sourceLines(name, text)
+		objs = append(objs, AssembleObject(lines, wheres, nil))
+		names = append(names, name+".obj")
+	}
+	return Link(objs, names, diags)
+}
+
+// TestLinkLocalLabel checks that an object's own label is not
+// replaced by another object's label of the same name.
+func TestLinkLocalLabel(t *testing.T) {
+	diags := &Diagnostics{}
+	mod, err := linkTexts(t, diags, "here\tfcb 1\n\tfcb L(here)\n", "\tfcb 2\nhere\tfcb 3\n", "\tfcb L(here)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%x", ModSegments(mod)); got != "[{0 0100020303}]" {
+		t.Errorf("got %s", got)
+	}
+	want := `mod1.obj: warning: symbol "here" is also defined by mod0.obj; other objects get this one` + "\n"
+	if got := diags.String(); got != want {
+		t.Errorf("got warnings %q", got)
+	}
+
+	_, err = linkTexts(t, nil, "a\tequ b+1\n", "b\tequ a+1\n")
+	if err == nil || err.Error() != `symbol "a", defined by mod0.obj, depends on itself` {
+		t.Errorf("got error %v", err)
+	}
+}
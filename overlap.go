@@ -0,0 +1,46 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+)
+
+// Generating two bytes at the same address is usually a mistake
+// (an ORG in the wrong place, or code running into a ROW table),
+// so the assembler and the linker report it, naming both sources.
+// Rows between OVERLAY and ENDOVERLAY may overwrite what was
+// generated before them, when that is intended:
+//
+//	        org Patch
+//	        overlay
+//	        fcb $42
+//	        endoverlay
+
+// ownership records where the byte at each address was generated.
+type ownership struct {
+	pages [RamSize / PageSize]*[PageSize]string
+}
+
+// claim records that where generated the byte at addr,
+// returning where the byte there was generated before, if anywhere.
+func (o *ownership) claim(addr uint, where string) (previous string) {
+	p := (addr & (RamSize - 1)) / PageSize
+	if o.pages[p] == nil {
+		o.pages[p] = new([PageSize]string)
+	}
+	previous = o.pages[p][addr%PageSize]
+	o.pages[p][addr%PageSize] = where
+	return previous
+}
+
+// overlapMessage describes generating addr over the byte from previous.
+func overlapMessage(addr uint, previous string) string {
+	return fmt.Sprintf("overlapping output at $%06x overwrites the byte from %s (use OVERLAY if that is intended)", addr, previous)
+}
+
+// whereOf names the source of a row, for overlap errors.
+func whereOf(row *Row) string {
+	if row.where != "" {
+		return row.where
+	}
+	return fmt.Sprintf("%q", row.opcode)
+}
@@ -0,0 +1,99 @@
+package ABhL // pronounced "owl"
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// assemblePanic assembles the text, returning what it panics with.
+func assemblePanic(text string) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	assembleText(text)
+	return ""
+}
+
+func TestOverlapReported(t *testing.T) {
+	for _, tc := range []struct {
+		name, text string
+		want       []string
+	}{
+		{"org", "\torg $100\n\tfcb 1\n\tfcb 2\n\torg $101\n\tfcb 3\n",
+			[]string{"text:21:2: error: overlapping output at $000101 overwrites the byte from text:19"}},
+		{"fcw", "\torg $100\n\tfcw $1234\n\torg $101\n\tseta 5\n",
+			[]string{"text:20:2: error: overlapping output at $000101 overwrites the byte from text:18"}},
+		{"row", "\tbank\nsq\trow _L_\n\torg $10080\n\tfcb 1\n",
+			[]string{"text:20:2: error: overlapping output at $010080 overwrites the byte from text:18"}},
+		{"nested", "\toverlay\n\toverlay\n\tendoverlay\n\tendoverlay\n",
+			[]string{"OVERLAY inside OVERLAY"}},
+		{"unopened", "\tendoverlay\n",
+			[]string{"ENDOVERLAY without OVERLAY"}},
+		{"unclosed", "\toverlay\n\tfcb 1\n",
+			[]string{"OVERLAY without ENDOVERLAY"}},
+	} {
+		msg := assemblePanic(tc.text)
+		for _, want := range tc.want {
+			if !strings.Contains(msg, want) {
+				t.Errorf("%s: got %q, want %q", tc.name, msg, want)
+			}
+		}
+	}
+}
+
+func TestOverlayAllowed(t *testing.T) {
+	mod := assembleText("\torg $100\n\tfcb 1\n\tfcb 2\n\torg $101\n\toverlay\n\tfcb 3\n\tendoverlay\n\tfcb 4\n")
+	segs := ModSegments(mod)
+	if got := fmt.Sprintf("%x", segs); got != "[{100 010304}]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestLinkOverlap(t *testing.T) {
+	link := func(texts ...string) (*Mod, error) {
+		var objs []*Object
+		var names []string
+		for i, text := range texts {
+			name := fmt.Sprintf("mod%d", i)
+			lines, wheres := sourceLines(name, text)
+			objs = append(objs, AssembleObject(lines, wheres, nil))
+			names = append(names, name+".obj")
+		}
+		return Link(objs, names, nil)
+	}
+
+	// Relocatable code in the first object runs into the ORG of the second.
+	_, err := link("\tfcb 1\n\tfcb 2\n", "\torg 1\n\tfcb 3\n")
+	want := "mod1.obj: overlapping output at $000001 overwrites the byte from mod0.obj (use OVERLAY if that is intended)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v", err)
+	}
+
+	// A relocated byte names its row.
+	_, err = link("\torg $100\nhere\tfcb 1\n", "\torg $100\n\tfcb L(here)\n")
+	if err == nil || !strings.Contains(err.Error(), "mod1:2: overlapping output at $000100 overwrites the byte from mod0.obj") {
+		t.Errorf("got error %v", err)
+	}
+
+	mod, err := link("\tfcb 1\n\tfcb 2\n", "\torg 1\n\toverlay\n\tfcb 3\n\tendoverlay\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%x", ModSegments(mod)); got != "[{0 0103}]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestObjectOverlap(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), "one:4:2: error: overlapping output at $000100 overwrites the byte from one:2") {
+			t.Errorf("got %v", r)
+		}
+	}()
+	lines, wheres := sourceLines("one", "\torg $100\n\tfcb 1\n\torg $100\n\tfcb 2\n")
+	AssembleObject(lines, wheres, nil)
+}
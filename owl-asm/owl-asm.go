@@ -2,36 +2,68 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
+	"os"
 
 	OWL "github.com/strickyak/ABhL"
 )
 
-var O = flag.String("o", "", "write IPL to this file")
+var O = flag.String("o", "", "write IPL (or -format, or with -c an object) to this file")
+var FORMAT = flag.String("format", "ipl", "format of -o: ipl, compact (a smaller IPL), ihex (Intel HEX), srec (S-records), or bin (raw, from the lowest address)")
+var SYM = flag.String("sym", "", "write symbols (labels and their values) to this file")
+var BOOTSTRAP = flag.Bool("bootstrap", false, "build GENERABLE tables on the machine, from seed data, instead of loading them")
+var C = flag.Bool("c", false, "write a relocatable object file to -o, for owl-ld")
+var MAXERRORS = flag.Int("maxerrors", 20, "stop after this many errors (0 for no limit)")
+
+// report writes the errors and warnings, like a compiler,
+// and exits if there are errors.
+func report(diags *OWL.Diagnostics) {
+	diags.Write(os.Stderr)
+	if n := diags.Errors(); n > 0 {
+		log.Fatalf("FATAL: owl-asm: errors: %d", n)
+	}
+}
 
 func main() {
 	log.SetFlags(0)
 	flag.Parse()
 
-	var lines []string
-	var wheres []string
-	for _, arg := range flag.Args() {
-		slurp := OWL.SlurpTextFile(arg)
-		lines = append(lines, slurp...)
-		for i := 1; i <= len(slurp); i++ {
-			wheres = append(wheres, fmt.Sprintf("%s:%d", arg, i))
+	diags := &OWL.Diagnostics{MaxErrors: *MAXERRORS}
+	lines, wheres := OWL.ReadSources(flag.Args(), diags)
+	report(diags)
+
+	if *C {
+		obj := OWL.AssembleToObject(lines, wheres, os.Stdout, diags)
+		report(diags)
+		obj.Sources = flag.Args()
+		if *O != "" {
+			OWL.WriteObject(obj, *O)
 		}
+		return
 	}
 
-	mod := OWL.ParseLines(lines, wheres)
-	OWL.MacroPassOne(mod)
-	OWL.MacroPassTwo(mod)
-	OWL.PassOne(mod)
-	OWL.PassTwo(mod)
-	OWL.PassThree(mod)
+	mod := OWL.Assemble(lines, wheres, os.Stdout, diags)
+	report(diags)
+	image := mod
+	if *BOOTSTRAP {
+		var report *OWL.BootstrapReport
+		image, report = OWL.Bootstrap(mod)
+		log.Printf("owl-asm: bootstrap in bank $%x builds %v (%d bytes) from %d bytes of seed, in %d steps",
+			report.Bank, report.Built, report.TableBytes, report.SeedBytes, report.Steps)
+		if len(report.Loaded) > 0 {
+			log.Printf("owl-asm: generable tables %v would not be smaller as seed; they are loaded", report.Loaded)
+		}
+	}
 
 	if *O != "" {
-		OWL.WriteIPL(mod, *O)
+		size := OWL.WriteImage(image, *O, *FORMAT)
+		if *FORMAT == "compact" {
+			full := len(OWL.CreateIPL(image))
+			log.Printf("owl-asm: compact IPL is %d bytes, instead of %d (%.1f%% smaller)",
+				size, full, 100*float64(full-size)/float64(full))
+		}
+	}
+	if *SYM != "" {
+		OWL.WriteSymbols(mod, *SYM)
 	}
 }
@@ -0,0 +1,47 @@
+// owl-decode writes the contents of the decode ROMs (four 2716 EPROMs)
+// for the netlist in hardware/, and the same decoding as a truth table
+// or as PAL equations.
+//
+//	go run owl-decode/owl-decode.go -o hardware        # decode0.bin ... decode3.bin
+//	go run owl-decode/owl-decode.go -table             # truth table to stdout
+//	go run owl-decode/owl-decode.go -equations         # PAL equations to stdout
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/strickyak/ABhL/netsim"
+)
+
+var O = flag.String("o", "", "write the ROM images decode0.bin to decode3.bin in this directory")
+var TABLE = flag.Bool("table", false, "write the truth table of the control signals")
+var EQUATIONS = flag.Bool("equations", false, "write minimized PAL equations of the control signals")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if *O == "" && !*TABLE && !*EQUATIONS {
+		log.Fatalf("FATAL: Use -o, -table, or -equations")
+	}
+
+	if *O != "" {
+		for lane := 0; lane < 4; lane++ {
+			filename := filepath.Join(*O, fmt.Sprintf("decode%d.bin", lane))
+			err := ioutil.WriteFile(filename, netsim.DecodeROM(lane), 0644)
+			if err != nil {
+				log.Fatalf("FATAL: Cannot write ROM image %q: %v", filename, err)
+			}
+		}
+	}
+	if *TABLE {
+		netsim.WriteTruthTable(os.Stdout)
+	}
+	if *EQUATIONS {
+		netsim.WriteEquations(os.Stdout)
+	}
+}
@@ -0,0 +1,75 @@
+// owl-dis disassembles ABhL machine code into assembler source.
+//
+//	go run owl-dis/owl-dis.go -ipl prog.ipl -sym prog.sym     # what the IPL loads
+//	go run owl-dis/owl-dis.go -image rom.bin -org 0x100       # a raw memory image
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var IPL = flag.String("ipl", "", "simulate loading this IPL file, and disassemble what it loads")
+var IMAGE = flag.String("image", "", "disassemble this raw memory image")
+var ORG = flag.Uint("org", 0, "address of the first byte of -image")
+var SYM = flag.String("sym", "", "label addresses with the symbol file from owl-asm -sym")
+var FROM = flag.Uint("from", 0, "disassemble only from this address (like 0x100)")
+var TO = flag.Uint("to", 0, "disassemble only up to (not including) this address")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if (*IPL == "") == (*IMAGE == "") {
+		log.Fatalf("FATAL: Use one of -ipl or -image")
+	}
+
+	var syms OWL.Symbols
+	if *SYM != "" {
+		var err error
+		syms, err = OWL.ReadSymbolFile(*SYM)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read symbols: %v", err)
+		}
+	}
+
+	var mem OWL.Memory
+	var regions []OWL.Region
+	if *IPL != "" {
+		ipl, err := ioutil.ReadFile(*IPL)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read IPL file %q: %v", *IPL, err)
+		}
+		if len(ipl)%2 != 0 {
+			log.Fatalf("FATAL: IPL file %q has an odd length: %d", *IPL, len(ipl))
+		}
+		vm, loaded := OWL.IPLRegions(ipl)
+		fmt.Printf("; start at $%06x\n", vm.PC())
+		mem, regions = vm, loaded
+	} else {
+		bb, err := ioutil.ReadFile(*IMAGE)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read image %q: %v", *IMAGE, err)
+		}
+		mem = &OWL.Image{Base: *ORG, Bytes: bb}
+		regions = []OWL.Region{{Start: *ORG, End: *ORG + uint(len(bb))}}
+	}
+
+	for _, r := range regions {
+		if *FROM > r.Start {
+			r.Start = *FROM
+		}
+		if *TO != 0 && *TO < r.End {
+			r.End = *TO
+		}
+		if r.Start >= r.End {
+			continue
+		}
+		fmt.Printf("\n; %s\n", r)
+		OWL.WriteDisassembly(os.Stdout, mem, r, syms)
+	}
+}
@@ -1,100 +1,616 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
 
 	OWL "github.com/strickyak/ABhL"
 )
 
 var IPL = flag.String("ipl", "", "filename of bytes for Initial Program Load")
+var LOAD = flag.String("load", "", "load this image file directly into RAM, instead of -ipl")
+var FORMAT = flag.String("format", "", "format of -load: ihex, srec, or bin (default from its extension: .hex, .srec, or other)")
+var ORG = flag.Uint("org", 0, "address to load a bin image")
+var START = flag.Int("start", -1, "address to start a -load image (default from the image, or -org)")
 var MAX = flag.Int("max", 0, "Max number of steps to execute, after IPL (nonpositive means MaxInt)")
+var TIMEOUT = flag.Duration("timeout", 0, "Max wall-clock time to run, after IPL (zero means forever)")
+var HZ = flag.Uint64("hz", 0, "throttle to this clock rate, in cycles per second, like 1000000 (zero means as fast as possible)")
+var STATS_HZ = flag.Uint64("stats_hz", 1000000, "clock rate for reporting hardware run time, if not throttled by -hz")
+var VCD = flag.String("vcd", "", "write every half-cycle of the buses, registers, and strobes to this Value Change Dump file, for GTKWave")
+var SYM = flag.String("sym", "", "read symbols from this file (from owl-asm -sym), to name addresses")
+var STOP_ON_LOOP = flag.Bool("stop_on_loop", false, "stop when the program is in a tight loop that can never exit, like a jump to itself")
+var LOOP_STATUS = flag.Int("loop_status", 0, "exit status if stopped by -stop_on_loop")
+var MAX_STATUS = flag.Int("max_status", 125, "exit status if stopped by -max")
+var TIMEOUT_STATUS = flag.Int("timeout_status", 124, "exit status if stopped by -timeout")
+var INTERRUPT_STATUS = flag.Int("interrupt_status", 130, "exit status if stopped by an interrupt signal")
+var UNDEFINED_STATUS = flag.Int("undefined_status", 126, "exit status if stopped by an undefined instruction (like $00)")
+var RECORD = flag.String("record", "", "record every byte read from a port, with its step number, to this file")
+var REPLAY = flag.String("replay", "", "replay bytes read from ports from this file, made by -record")
+var EOF = flag.String("eof", "fault", "what reading port F does at end of input: zero (read $00), ff (read $FF), or fault (stop the emulator)")
+var NONBLOCK = flag.Bool("nonblock", false, "reading port F never waits for input; read port E for the status of port F")
+var SCRIPT = flag.String("script", "", "run this expect-style script against the program, instead of using the terminal")
+var PANEL = flag.Bool("panel", false, "show a full-screen front panel, with keys to run, stop, and step")
+var RAW = flag.Bool("raw", false, "if stdin is a terminal, put it in raw mode (no echo, no line editing)")
 
-type ReadArgsWriteExit struct {
-	args []byte
+// PanelPorts are ports E and F in -panel mode.
+// Keys typed in input mode are queued for port F,
+// and bytes written to port F are kept to show on the panel.
+type PanelPorts struct {
+	input  []byte
+	output []byte
 }
 
-func NewReadArgsWriteExit() *ReadArgsWriteExit {
-	var args []byte
-	for _, a := range flag.Args() {
-		args = append(args, []byte(a)...) // append bytes from the arg
-		args = append(args, 0)            // terminated by a 0 byte
+func (pp *PanelPorts) Read() byte {
+	if len(pp.input) == 0 {
+		return 0 // only in -nonblock mode; otherwise the panel waits
 	}
-	return &ReadArgsWriteExit{args: args}
+	x := pp.input[0]
+	pp.input = pp.input[1:]
+	return x
 }
 
-func (rawe *ReadArgsWriteExit) Read() byte {
-	if rawe.args != nil {
-		z := rawe.args[0]
-		rawe.args = rawe.args[1:]
-		return z
+func (pp *PanelPorts) Write(x byte) {
+	pp.output = append(pp.output, x)
+}
+
+// PanelStatus is port E in -panel mode, reporting StatusReady.
+type PanelStatus struct {
+	pp *PanelPorts
+}
+
+func (ps *PanelStatus) Read() byte {
+	if len(ps.pp.input) > 0 {
+		return OWL.StatusReady
+	}
+	return 0
+}
+
+func (ps *PanelStatus) Write(x byte) {
+	Fatalf("FATAL: Cannot write $%02x to the terminal status port", x)
+}
+
+// Panel speeds, in steps per second.  Zero means as fast as possible.
+var PanelSpeeds = []int{1, 3, 10, 30, 100, 300, 1000, 10000, 100000, 1000000, 0}
+
+const PanelFrame = 50 * time.Millisecond
+
+// Panel is the full-screen front panel of -panel mode.
+type Panel struct {
+	vm      *OWL.Vm
+	ports   *PanelPorts
+	exit    *OWL.ArgsExit
+	syms    OWL.Symbols
+	running bool
+	typing  bool // keys go to port F, until ESC
+	speed   int  // index in PanelSpeeds
+	budget  float64
+	halted  string // why the Vm can run no more, if it cannot
+	message string
+	history []uint // addresses of recent steps
+}
+
+// waitingForInput tells if the next step reads port F, with nothing to read.
+func (p *Panel) waitingForInput() bool {
+	return !*NONBLOCK && len(p.ports.input) == 0 && OWL.ReadsPortF(p.vm.Peek(p.vm.PC()))
+}
+
+// step executes one step, unless the Vm is halted or waiting for input.
+func (p *Panel) step() bool {
+	if p.halted != "" || p.waitingForInput() {
+		return false
+	}
+	p.history = append(p.history, p.vm.PC())
+	if len(p.history) > 4 {
+		p.history = p.history[1:]
+	}
+	if !p.vm.Steps(1) {
+		switch {
+		case p.exit.Exited:
+			p.halted = fmt.Sprintf("EXITED with status %d", p.exit.Status)
+		case p.vm.Stopped():
+			p.halted = "STOPPED"
+		case p.message != "":
+			return false // OnLoop stopped it; it may continue
+		default:
+			p.halted = "HALTED on undefined instruction"
+		}
+		p.running = false
+		return false
+	}
+	return true
+}
+
+// run executes the steps due in one frame, at the current speed.
+func (p *Panel) run() {
+	speed := PanelSpeeds[p.speed]
+	if speed == 0 {
+		until := time.Now().Add(PanelFrame * 8 / 10)
+		for time.Now().Before(until) {
+			for i := 0; i < 1000; i++ {
+				if !p.step() {
+					return
+				}
+			}
+		}
+		return
+	}
+	p.budget += float64(speed) * PanelFrame.Seconds()
+	for ; p.budget >= 1; p.budget-- {
+		if !p.step() {
+			p.budget = 0
+			return
+		}
+	}
+}
+
+// key handles a key press.  It returns false to quit.
+func (p *Panel) key(k byte) bool {
+	if p.typing {
+		switch k {
+		case 27: // ESC
+			p.typing = false
+		case '\r':
+			p.ports.input = append(p.ports.input, '\n')
+		default:
+			p.ports.input = append(p.ports.input, k)
+		}
+		return true
+	}
+	p.message = ""
+	switch k {
+	case 'q', 3: // 3 is ^C
+		return false
+	case ' ', 'r':
+		p.running = !p.running && p.halted == ""
+		p.budget = 0
+	case 's', 'n':
+		p.running = false
+		if p.waitingForInput() {
+			p.message = "waiting for input: press i to type"
+		}
+		p.step()
+	case '+', '=':
+		if p.speed+1 < len(PanelSpeeds) {
+			p.speed++
+		}
+	case '-':
+		if p.speed > 0 {
+			p.speed--
+		}
+	case 'i':
+		p.typing = true
+	}
+	return true
+}
+
+// draw redraws the whole screen.
+func (p *Panel) draw(w io.Writer) {
+	vm := p.vm
+	var sb strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&sb, format, args...)
+		sb.WriteString("\x1b[K\r\n") // erase to end of line
+	}
+	sb.WriteString("\x1b[H") // home
+
+	state := "STOPPED"
+	switch {
+	case p.halted != "":
+		state = p.halted
+	case p.running && p.waitingForInput():
+		state = "WAITING FOR INPUT"
+	case p.running:
+		state = "RUNNING"
+	}
+	speed := "max"
+	if s := PanelSpeeds[p.speed]; s > 0 {
+		speed = fmt.Sprint(s)
+	}
+	line("\x1b[7m ABhL front panel \x1b[0m  %s   speed %s steps/s", state, speed)
+	line("step %d   cycles %d", vm.StepNum(), vm.Cycles())
+	line("")
+	line("A=%02x  B=%02x  H=%02x  L=%02x   W=%06x  PC=%06x  T=%02x", vm.A(), vm.B(), vm.H(), vm.L(), vm.W(), vm.PC(), vm.T())
+	for _, base := range []uint{0, 8} {
+		var qs strings.Builder
+		for i := base; i < base+8; i++ {
+			fmt.Fprintf(&qs, " %02x", vm.Peek(i))
+		}
+		line("%-8s%s", fmt.Sprintf("Q%d-Q%d:", base, base+7), qs.String())
+	}
+
+	line("")
+	line("Memory around W:")
+	w0 := vm.W() &^ 15
+	for row := uint(0); row < 4; row++ {
+		addr := (w0 + row*16 - 16) & (OWL.RamSize - 1)
+		var ms strings.Builder
+		for i := uint(0); i < 16; i++ {
+			a := (addr + i) & (OWL.RamSize - 1)
+			if a == vm.W() {
+				fmt.Fprintf(&ms, "[%02x]", vm.Peek(a))
+			} else if a == vm.W()+1 {
+				fmt.Fprintf(&ms, "%02x", vm.Peek(a))
+			} else {
+				fmt.Fprintf(&ms, " %02x", vm.Peek(a))
+			}
+		}
+		line("%06x:%s", addr, ms.String())
+	}
+
+	line("")
+	line("Disassembly:")
+	dis := func(mark string, addr uint) int {
+		text, size := OWL.Disasm(vm.Peek(addr), vm.Peek(addr+1))
+		bytes := fmt.Sprintf("%02x", vm.Peek(addr))
+		if size == 2 {
+			bytes += fmt.Sprintf(" %02x", vm.Peek(addr+1))
+		}
+		line("%s %06x  %-6s %-10s %s", mark, addr, bytes, text, p.syms.Lookup(addr))
+		return size
+	}
+	for i := len(p.history); i < 4; i++ {
+		line("")
+	}
+	for _, addr := range p.history {
+		dis(" ", addr)
+	}
+	addr := vm.PC()
+	for i := 0; i < 6; i++ {
+		mark := " "
+		if i == 0 {
+			mark = ">"
+		}
+		addr += uint(dis(mark, addr))
+	}
+
+	line("")
+	line("Port F output:")
+	out := strings.ReplaceAll(string(p.ports.output), "\r", "")
+	lines := strings.Split(out, "\n")
+	if len(lines) > 4 {
+		lines = lines[len(lines)-4:]
+	}
+	for i := len(lines); i < 4; i++ {
+		line("")
+	}
+	for _, s := range lines {
+		line("  %q", s)
+	}
+
+	line("")
+	if p.typing {
+		line("\x1b[7m TYPING \x1b[0m keys go to port F (%d queued); ESC to stop typing", len(p.ports.input))
 	} else {
-		return 0 // return 0's after args are exhausted
+		line("keys: space run/stop  s step  + - speed  i type input  q quit   %s", p.message)
 	}
+	sb.WriteString("\x1b[J") // erase the rest of the screen
+	io.WriteString(w, sb.String())
 }
 
-func (rawe *ReadArgsWriteExit) Write(status byte) {
-	log.Printf("ReadArgsWriteExit: EXIT $%02x", status)
-	os.Exit(int(status))
+// RunPanel runs the Vm under the front panel, until the user quits.
+func RunPanel(vm *OWL.Vm, ports *PanelPorts, exit *OWL.ArgsExit, syms OWL.Symbols) {
+	if !IsTerminal(os.Stdin) {
+		log.Fatalf("FATAL: -panel needs a terminal on stdin")
+	}
+	MakeRaw()
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	AtExit(func() { os.Stdout.WriteString("\x1b[?25h\x1b[?1049l") })
+
+	p := &Panel{vm: vm, ports: ports, exit: exit, syms: syms, speed: 2}
+	vm.OnLoop = func(addr uint) bool {
+		p.message = fmt.Sprintf("tight loop at BNZ $%06x %s", addr, syms.Lookup(addr))
+		p.running = false
+		return true
+	}
+
+	keys := make(chan byte, 64)
+	go func() {
+		bb := []byte{0}
+		for {
+			n, err := os.Stdin.Read(bb)
+			if n == 1 {
+				keys <- bb[0]
+			}
+			if err != nil {
+				close(keys)
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(PanelFrame)
+	defer ticker.Stop()
+	for {
+		p.draw(os.Stdout)
+		select {
+		case k, ok := <-keys:
+			if !ok || !p.key(k) {
+				if exit.Exited {
+					Exit(int(exit.Status))
+				}
+				Exit(0)
+			}
+		case <-ticker.C:
+			if p.running {
+				p.run()
+			}
+		}
+	}
+}
+
+// IsTerminal tells if the file is a TTY.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && (fi.Mode()&os.ModeCharDevice) != 0
+}
+
+// MakeRaw puts the TTY on stdin in raw mode, using stty,
+// and registers a cleanup to restore its old mode at exit.
+func MakeRaw() {
+	stty := func(args ...string) string {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		out, err := cmd.Output()
+		if err != nil {
+			log.Fatalf("FATAL: stty %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	saved := stty("-g")
+	stty("raw", "-echo")
+	AtExit(func() { stty(saved) })
+}
+
+var cleanups []func()
+
+// AtExit registers fn to be called by Exit and Fatalf.
+func AtExit(fn func()) {
+	cleanups = append(cleanups, fn)
+}
+
+// runCleanups calls the cleanups, the last registered first.
+// If one panics, the rest still run, so the terminal is restored.
+func runCleanups() {
+	if len(cleanups) == 0 {
+		return
+	}
+	fn := cleanups[len(cleanups)-1]
+	cleanups = cleanups[:len(cleanups)-1]
+	defer runCleanups()
+	fn()
+}
+
+// Exit runs the cleanups and then exits with the status.
+func Exit(status int) {
+	runCleanups()
+	os.Exit(status)
 }
 
-type Terminal struct {
-	r io.Reader
-	w io.Writer
+// Fatalf is like log.Fatalf, but runs the cleanups first.
+func Fatalf(format string, args ...any) {
+	runCleanups()
+	log.Fatalf(format, args...)
+}
+
+// RunScript runs the script in place of the terminal, and exits:
+// with status 0 if the script passes, or 1 if it fails.
+func RunScript(vm *OWL.Vm, filename string) {
+	r, err := os.Open(filename)
+	if err != nil {
+		Fatalf("FATAL: Cannot open script file %q: %v", filename, err)
+	}
+	script, err := OWL.ParseScript(r, filename)
+	r.Close()
+	if err != nil {
+		Fatalf("FATAL: %v", err)
+	}
+	err = script.Run(vm, flag.Args(), os.Stdout)
+	if err != nil {
+		runCleanups()
+		log.Printf("owl-emu: SCRIPT FAILED: %v", err)
+		Exit(1)
+	}
+	log.Printf("owl-emu: script passed after %d steps", vm.StepNum())
+	Exit(0)
 }
 
-func (term *Terminal) Read() byte {
-	bb := []byte{0}
-	n, err := term.r.Read(bb)
-	if err != nil || n != 1 {
-		log.Fatalf("FATAL: Terminal stopping on bad Read (%d; %v)", n, err)
+// LogStats logs how many instructions and clock cycles ran,
+// and how long that would take on the hardware.
+func LogStats(vm *OWL.Vm, elapsed time.Duration) {
+	hz := *HZ
+	if hz == 0 {
+		hz = *STATS_HZ
 	}
-	return bb[0]
+	log.Printf("owl-emu: %d instructions, %d cycles (with IPL), %v of hardware time at %d Hz, in %v",
+		vm.StepNum(), vm.Cycles(), OWL.HardwareTime(vm.Cycles(), hz), hz, elapsed.Round(time.Millisecond))
 }
 
-func (term *Terminal) Write(x byte) {
-	bb := []byte{x}
-	n, err := term.w.Write(bb)
-	if err != nil || n != 1 {
-		log.Fatalf("FATAL: Terminal stopping on bad Write (%d; %v)", n, err)
+// LoadImage reads the -load file, and where to start it.
+func LoadImage(filename string) ([]OWL.Segment, uint) {
+	bb, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot read image file %q: %v", filename, err)
 	}
+	format := *FORMAT
+	if format == "" {
+		format = OWL.FormatOf(filename)
+	}
+	segs, start, hasStart, err := OWL.ReadImage(bb, format, *ORG)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot load %s image %q: %v", format, filename, err)
+	}
+	if *START >= 0 {
+		start = uint(*START)
+	} else if !hasStart {
+		start = *ORG
+	}
+	return segs, start
 }
 
 func main() {
 	log.SetFlags(0) // dont need time and date
 	flag.Parse()
+	defer func() {
+		if r := recover(); r != nil {
+			runCleanups() // like restoring the terminal, before the panic is printed
+			panic(r)
+		}
+	}()
 
-	vec, err := ioutil.ReadFile(*IPL)
-	if err != nil {
-		log.Fatalf("FATAL: Cannot read IPL file %q: %v", *IPL, err)
+	if (*IPL == "") == (*LOAD == "") {
+		log.Fatalf("FATAL: Use one of -ipl or -load")
 	}
-
-	term := &Terminal{
-		r: os.Stdin,
-		w: os.Stdout,
+	var vec []byte
+	var segs []OWL.Segment
+	var start uint
+	if *IPL != "" {
+		var err error
+		vec, err = ioutil.ReadFile(*IPL)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read IPL file %q: %v", *IPL, err)
+		}
+	} else {
+		segs, start = LoadImage(*LOAD)
 	}
-	rawe := NewReadArgsWriteExit()
-	vm := &OWL.Vm{
-		F: term,
-		G: rawe,
+
+	vm := &OWL.Vm{}
+	var panelPorts *PanelPorts
+	var term *OWL.Terminal
+	if *PANEL {
+		panelPorts = &PanelPorts{}
+		vm.E, vm.F = &PanelStatus{panelPorts}, panelPorts
+		vm.Logf = func(format string, args ...any) {} // it would scribble on the panel
+	} else {
+		eof, err := OWL.ParseEOFMode(*EOF)
+		if err != nil {
+			log.Fatalf("FATAL: Bad value for -eof: %v", err)
+		}
+		term = OWL.NewTerminal(vm, os.Stdin, os.Stdout, eof, *NONBLOCK)
+		if *RAW && IsTerminal(os.Stdin) {
+			MakeRaw()
+			term.CRLF = true
+		}
+		vm.E, vm.F = &OWL.TerminalStatus{Term: term}, term
 	}
+	exit := OWL.NewArgsExit(vm, flag.Args())
+	vm.G = exit
 
-	vm.IPL(vec)
+	if *RECORD != "" && *REPLAY != "" {
+		log.Fatalf("FATAL: Cannot use both -record and -replay")
+	}
+	if *RECORD != "" {
+		w, err := os.Create(*RECORD)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot create record file %q: %v", *RECORD, err)
+		}
+		defer w.Close()
+		rec := OWL.NewRecorder(vm, w)
+		vm.E = rec.Wrap("E", vm.E)
+		vm.F = rec.Wrap("F", vm.F)
+		vm.G = rec.Wrap("G", vm.G)
+	}
+	if *REPLAY != "" {
+		r, err := os.Open(*REPLAY)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot open replay file %q: %v", *REPLAY, err)
+		}
+		events, err := OWL.ReadPortEvents(r)
+		r.Close()
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read replay file %q: %v", *REPLAY, err)
+		}
+		rep := OWL.NewReplayer(vm, events)
+		vm.E = rep.Wrap("E", vm.E)
+		vm.F = rep.Wrap("F", vm.F)
+		vm.G = rep.Wrap("G", vm.G)
+		AtExit(rep.Finish)
+	}
 
-	max := *MAX
-	if max < 1 {
-		const max_uint = ^(uint(0))
-		const max_int = int(max_uint >> 1)
-		max = max_int
+	if *VCD != "" {
+		w, err := os.Create(*VCD)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot create VCD file %q: %v", *VCD, err)
+		}
+		hz := *HZ
+		if hz == 0 {
+			hz = *STATS_HZ
+		}
+		vcd := OWL.NewVCDWriter(vm, w, hz)
+		AtExit(func() {
+			if err := vcd.Close(); err != nil {
+				log.Printf("owl-emu: Error writing VCD file %q: %v", *VCD, err)
+			}
+			w.Close()
+		})
 	}
-	ok := vm.Steps(max)
 
-	if ok {
-		log.Printf("owl-emu: Stopped after the max %d steps", *MAX)
+	if *LOAD != "" {
+		vm.Load(segs, start)
 	} else {
-		log.Printf("owl-emu: Stopped before reaching the max steps")
+		vm.IPL(vec)
+	}
+
+	if *SCRIPT != "" {
+		RunScript(vm, *SCRIPT)
+	}
+
+	var syms OWL.Symbols
+	if *SYM != "" {
+		var err error
+		syms, err = OWL.ReadSymbolFile(*SYM)
+		if err != nil {
+			Fatalf("FATAL: Cannot read symbol file %q: %v", *SYM, err)
+		}
+	}
+	if *PANEL {
+		RunPanel(vm, panelPorts, exit, syms)
+	}
+	vm.OnLoop = func(addr uint) bool {
+		log.Printf("owl-emu: HALT: tight loop at BNZ $%06x %s, after %d steps", addr, syms.Lookup(addr), vm.StepNum())
+		return *STOP_ON_LOOP
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	limits := OWL.Limits{}
+	if *MAX > 0 {
+		limits.MaxSteps = uint64(*MAX)
+	}
+	if *TIMEOUT > 0 {
+		limits.Deadline = time.Now().Add(*TIMEOUT)
+	}
+	limits.Hz = *HZ
+	began := time.Now()
+	why := vm.Run(ctx, limits)
+	elapsed := time.Since(began)
+
+	if term != nil && term.Err != nil {
+		LogStats(vm, elapsed)
+		Fatalf("FATAL: Terminal stopping on %v, after %d steps, at pc=%06x", term.Err, vm.StepNum(), vm.PC())
+	}
+	log.Printf("owl-emu: Stopped by %s after %d steps, at pc=%06x", why, vm.StepNum(), vm.PC())
+	LogStats(vm, elapsed)
+	switch why {
+	case OWL.StopExit:
+		Exit(int(exit.Status))
+	case OWL.StopMaxSteps:
+		Exit(*MAX_STATUS)
+	case OWL.StopDeadline:
+		Exit(*TIMEOUT_STATUS)
+	case OWL.StopCanceled:
+		Exit(*INTERRUPT_STATUS)
+	case OWL.StopLoop:
+		Exit(*LOOP_STATUS)
+	default:
+		Exit(*UNDEFINED_STATUS)
 	}
 }
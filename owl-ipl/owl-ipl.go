@@ -0,0 +1,46 @@
+// owl-ipl reads IPL files, without running them, and prints what
+// they load: the start address and a map of the regions of memory,
+// or all the bytes loaded with -hex.  The output is meant for diffing
+// the IPL files of different builds.
+//
+//	go run owl-ipl/owl-ipl.go prog.ipl
+//	go run owl-ipl/owl-ipl.go -hex prog.ipl > prog.hex
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var HEX = flag.Bool("hex", false, "also write every byte loaded, 16 to a line")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalf("FATAL: Name IPL files to read")
+	}
+
+	for _, filename := range flag.Args() {
+		ipl, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read IPL file %q: %v", filename, err)
+		}
+		m, err := OWL.DecompileIPL(ipl)
+		if err != nil {
+			log.Fatalf("FATAL: In IPL file %q: %v", filename, err)
+		}
+		if flag.NArg() > 1 {
+			fmt.Printf("%s:\n", filename)
+		}
+		m.WriteMap(os.Stdout)
+		if *HEX {
+			m.WriteHex(os.Stdout)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// owl-isa writes the opcode table of the ABhL instruction set,
+// from the same ISA definition the assembler and emulator use.
+//
+//	go run owl-isa/owl-isa.go                            # table to stdout
+//	go run owl-isa/owl-isa.go -update architecture.md    # rewrite the table in the doc
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var UPDATE = flag.String("update", "", "rewrite the opcode table between the markers in this markdown file")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	if *UPDATE == "" {
+		OWL.WriteOpcodeTable(os.Stdout)
+		return
+	}
+
+	doc, err := ioutil.ReadFile(*UPDATE)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot read %q: %v", *UPDATE, err)
+	}
+	updated, err := OWL.UpdateOpcodeTable(string(doc))
+	if err != nil {
+		log.Fatalf("FATAL: In %q: %v", *UPDATE, err)
+	}
+	err = ioutil.WriteFile(*UPDATE, []byte(updated), 0644)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot write %q: %v", *UPDATE, err)
+	}
+}
@@ -0,0 +1,63 @@
+// owl-ld links relocatable object files from owl-asm -c.
+//
+//	go run owl-asm/owl-asm.go -c -o lib2.obj lib2.owl > /dev/null
+//	go run owl-asm/owl-asm.go -c -o hello.obj hello.cb.genowl lib1.owl > /dev/null
+//	go run owl-ld/owl-ld.go -o hello.ipl -sym hello.sym hello.obj lib2.obj
+//
+// The objects are placed in order, as if their sources had been
+// assembled together: each one's code before its first ORG follows
+// where the previous one ended.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var O = flag.String("o", "", "write the linked program to this file")
+var FORMAT = flag.String("format", "ipl", "format of -o: ipl, compact (a smaller IPL), ihex (Intel HEX), srec (S-records), or bin (raw, from the lowest address)")
+var SYM = flag.String("sym", "", "write symbols (labels and their values) to this file")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatalf("FATAL: Name object files to link")
+	}
+
+	var objs []*OWL.Object
+	diags := &OWL.Diagnostics{}
+	for _, filename := range flag.Args() {
+		obj, err := OWL.ReadObject(filename)
+		if err != nil {
+			diags.List = append(diags.List, OWL.FileDiagnostic(filename, err))
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	if n := diags.Errors(); n > 0 {
+		diags.Write(os.Stderr)
+		log.Fatalf("FATAL: owl-ld: errors: %d", n)
+	}
+
+	mod, err := OWL.Link(objs, flag.Args(), diags)
+	diags.Write(os.Stderr) // warnings
+	if err != nil {
+		log.Fatalf("FATAL: Cannot link: %v", err)
+	}
+
+	if *O != "" {
+		size := OWL.WriteImage(mod, *O, *FORMAT)
+		if *FORMAT == "compact" {
+			full := len(OWL.CreateIPL(mod))
+			log.Printf("owl-ld: compact IPL is %d bytes, instead of %d (%.1f%% smaller)",
+				size, full, 100*float64(full-size)/float64(full))
+		}
+	}
+	if *SYM != "" {
+		OWL.WriteSymbols(mod, *SYM)
+	}
+}
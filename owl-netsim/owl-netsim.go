@@ -0,0 +1,65 @@
+// owl-netsim runs a program on a gate-level simulation of a netlist,
+// in lockstep with the emulator, and reports the first cycle where they disagree.
+//
+//	go run owl-netsim/owl-netsim.go -netlist hardware/abhl.json -ipl prog.ipl [args...]
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+	"github.com/strickyak/ABhL/netsim"
+)
+
+var NETLIST = flag.String("netlist", "hardware/abhl.json", "JSON netlist of the circuit")
+var IPL = flag.String("ipl", "", "filename of bytes for Initial Program Load")
+var MAX = flag.Int("max", 1000000, "max number of steps to execute, after IPL")
+var STDIN = flag.String("stdin", "", "file of bytes the program reads from port F (then 0's)")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	nl, err := netsim.LoadNetlist(*NETLIST)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	sim, err := netsim.NewSim(nl)
+	if err != nil {
+		log.Fatalf("FATAL: netlist %q: %v", *NETLIST, err)
+	}
+	vec, err := ioutil.ReadFile(*IPL)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot read IPL file %q: %v", *IPL, err)
+	}
+	var stdin []byte
+	if *STDIN != "" {
+		stdin, err = ioutil.ReadFile(*STDIN)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read stdin file %q: %v", *STDIN, err)
+		}
+	}
+
+	vm := &OWL.Vm{Logf: func(format string, args ...any) {}}
+	term := &OWL.BufferPort{Input: stdin}
+	exit := OWL.NewArgsExit(vm, flag.Args())
+	vm.F, vm.G = term, exit
+	ls, err := netsim.NewLockstep(vm, sim)
+	if err != nil {
+		log.Fatalf("FATAL: netlist %q: %v", *NETLIST, err)
+	}
+
+	err = ls.IPL(vec)
+	if err == nil {
+		_, err = ls.Steps(*MAX)
+	}
+	os.Stdout.Write(term.Output.Bytes())
+	if err != nil {
+		log.Fatalf("owl-netsim: MISMATCH: %v", err)
+	}
+	log.Printf("owl-netsim: OK: circuit and emulator agree for %d cycles (%d steps); exited=%v status=%d",
+		vm.Cycles(), vm.StepNum(), exit.Exited, exit.Status)
+}
@@ -0,0 +1,304 @@
+package main
+
+// owl-test runs golden-output tests of IPL programs.
+//
+// A test is a directory containing a file named want.stdout or want.status.
+// The test directory may also contain:
+//
+//	*.cb      -- cflat sources, compiled and assembled first
+//	*.owl     -- assembler sources, assembled next
+//	sources   -- names of more sources (like ../../lib1.owl), one per line, assembled last
+//	stdin     -- bytes for the program to read from port F
+//	args      -- arguments for the program to read from port G, one per line
+//	want.stdout -- the bytes the program must write to port F
+//	want.status -- the exit status the program must write to port G (default 0)
+//
+// Usage:
+//
+//	go run owl-test/owl-test.go [-j 8] [-update] dirs...
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var J = flag.Int("j", runtime.NumCPU(), "number of tests to run in parallel")
+var MAX = flag.Int("max", 10000000, "Max number of steps for each test to execute, after IPL")
+var CFLAT = flag.String("cflat", "go run github.com/strickyak/ABhL/cflat", "command to compile .cb files")
+var UPDATE = flag.Bool("update", false, "rewrite the golden files with the actual results")
+var V = flag.Bool("v", false, "verbose: log every step of the emulator, for each test")
+
+type Result struct {
+	dir    string
+	err    error // problem building or running the test
+	stdout []byte
+	status byte
+	failed bool
+	report string // diffs, if failed
+	log    bytes.Buffer
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	dirs := FindTests(roots)
+	results := make([]*Result, len(dirs))
+	sem := make(chan bool, *J)
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			sem <- true
+			results[i] = RunTest(dir)
+			<-sem
+		}(i, dir)
+	}
+	wg.Wait()
+
+	passed, failed := 0, 0
+	for _, r := range results {
+		if *V {
+			fmt.Printf("=== %s\n%s", r.dir, r.log.String())
+		}
+		if r.failed {
+			failed++
+			fmt.Printf("FAIL %s\n%s", r.dir, r.report)
+		} else {
+			passed++
+			fmt.Printf("ok   %s\n", r.dir)
+		}
+	}
+	fmt.Printf("owl-test: %d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// FindTests returns the sorted names of test directories under the roots.
+func FindTests(roots []string) (dirs []string) {
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				name := filepath.Base(path)
+				if name == "want.stdout" || name == "want.status" {
+					dirs = append(dirs, filepath.Dir(path))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("FATAL: Cannot search for tests in %q: %v", root, err)
+		}
+	}
+	sort.Strings(dirs)
+	// A directory with both golden files was added twice.
+	var uniq []string
+	for _, d := range dirs {
+		if len(uniq) == 0 || uniq[len(uniq)-1] != d {
+			uniq = append(uniq, d)
+		}
+	}
+	return uniq
+}
+
+func RunTest(dir string) (r *Result) {
+	r = &Result{dir: dir}
+	defer func() {
+		if e := recover(); e != nil {
+			r.err = fmt.Errorf("%v", e)
+		}
+		if r.err != nil {
+			r.failed = true
+			r.report = fmt.Sprintf("    %v\n", r.err)
+		}
+	}()
+
+	sources, cleanup, err := Sources(dir)
+	defer cleanup()
+	if err != nil {
+		r.err = err
+		return
+	}
+	mod := OWL.AssembleFiles(sources, nil)
+
+	stdin, _ := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+	var args []string
+	if bb, err := ioutil.ReadFile(filepath.Join(dir, "args")); err == nil {
+		args = strings.Split(strings.TrimRight(string(bb), "\n"), "\n")
+	}
+
+	vm := &OWL.Vm{}
+	vm.Logf = func(format string, args ...any) {
+		if *V {
+			fmt.Fprintf(&r.log, format+"\n", args...)
+		}
+	}
+	term := &OWL.BufferPort{Input: stdin}
+	exit := OWL.NewArgsExit(vm, args)
+	vm.F, vm.G = term, exit
+	vm.IPL(OWL.CreateIPL(mod))
+	vm.Steps(*MAX)
+	if !exit.Exited {
+		r.err = fmt.Errorf("did not exit within %d steps; stopped at pc=%06x; output so far: %q", vm.StepNum(), vm.PC(), term.Output.Bytes())
+		return
+	}
+	r.stdout = term.Output.Bytes()
+	r.status = exit.Status
+
+	if *UPDATE {
+		Check(ioutil.WriteFile(filepath.Join(dir, "want.stdout"), r.stdout, 0644))
+		Check(ioutil.WriteFile(filepath.Join(dir, "want.status"), []byte(fmt.Sprintf("%d\n", r.status)), 0644))
+		return
+	}
+
+	var report strings.Builder
+	wantStatus := byte(0)
+	if bb, err := ioutil.ReadFile(filepath.Join(dir, "want.status")); err == nil {
+		n, err := strconv.ParseUint(strings.TrimSpace(string(bb)), 0, 8)
+		if err != nil {
+			r.err = fmt.Errorf("bad want.status: %v", err)
+			return
+		}
+		wantStatus = byte(n)
+	}
+	if r.status != wantStatus {
+		fmt.Fprintf(&report, "    exit status %d, want %d\n", r.status, wantStatus)
+	}
+	if wantStdout, err := ioutil.ReadFile(filepath.Join(dir, "want.stdout")); err == nil {
+		if !bytes.Equal(r.stdout, wantStdout) {
+			fmt.Fprintf(&report, "    stdout differs (-want +got):\n%s", Diff(string(wantStdout), string(r.stdout)))
+		}
+	}
+	r.report = report.String()
+	r.failed = r.report != ""
+	return
+}
+
+// Sources lists the files to assemble for the test in dir,
+// compiling .cb files in a temporary directory,
+// which is removed by calling cleanup.
+func Sources(dir string) (sources []string, cleanup func(), err error) {
+	cleanup = func() {}
+	cbs, _ := filepath.Glob(filepath.Join(dir, "*.cb"))
+	owls, _ := filepath.Glob(filepath.Join(dir, "*.owl"))
+
+	if len(cbs) > 0 {
+		tmp, err := ioutil.TempDir("", "owl-test")
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { os.RemoveAll(tmp) }
+		for _, cb := range cbs {
+			genowl, err := CompileCb(cb, tmp)
+			if err != nil {
+				return nil, cleanup, err
+			}
+			sources = append(sources, genowl)
+		}
+	}
+	sources = append(sources, owls...)
+	if bb, err := ioutil.ReadFile(filepath.Join(dir, "sources")); err == nil {
+		for _, line := range strings.Split(string(bb), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, ";") {
+				sources = append(sources, filepath.Join(dir, line))
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return nil, cleanup, fmt.Errorf("no sources (*.cb, *.owl, or sources file)")
+	}
+	return sources, cleanup, nil
+}
+
+// CompileCb runs the cflat compiler on a copy of cb in tmp,
+// and returns the name of the resulting .genowl file.
+func CompileCb(cb, tmp string) (string, error) {
+	bb, err := ioutil.ReadFile(cb)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(tmp, filepath.Base(cb))
+	if err := ioutil.WriteFile(dest, bb, 0644); err != nil {
+		return "", err
+	}
+	words := strings.Fields(*CFLAT)
+	cmd := exec.Command(words[0], append(words[1:], dest)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("cannot compile %q: %v\n%s", cb, err, stderr.String())
+	}
+	return dest + ".genowl", nil
+}
+
+// Diff returns the lines that differ between want and got,
+// prefixed with "-" for want and "+" for got, using the longest common subsequence.
+func Diff(want, got string) string {
+	a := strings.SplitAfter(want, "\n")
+	b := strings.SplitAfter(got, "\n")
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	show := func(prefix, line string) {
+		fmt.Fprintf(&sb, "      %s%q\n", prefix, line)
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			show(" ", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			show("-", a[i])
+			i++
+		default:
+			show("+", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func Check(err error) {
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+}
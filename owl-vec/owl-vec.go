@@ -0,0 +1,87 @@
+// owl-vec runs a program in the emulator and writes the test vectors
+// the hardware should match, cycle by cycle; or, with -check,
+// compares vectors captured from the hardware against them.
+//
+//	go run owl-vec/owl-vec.go -ipl prog.ipl -o prog.vec [args...]
+//	go run owl-vec/owl-vec.go -ipl prog.ipl -check captured.vec [args...]
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	OWL "github.com/strickyak/ABhL"
+)
+
+var IPL = flag.String("ipl", "", "filename of bytes for Initial Program Load")
+var O = flag.String("o", "", "write vectors to this file (default stdout)")
+var FORMAT = flag.String("format", "csv", "vector format: csv or bin")
+var MAX = flag.Int("max", 1000000, "max number of steps to execute, after IPL")
+var STDIN = flag.String("stdin", "", "file of bytes the program reads from port F (then 0's)")
+var CHECK = flag.String("check", "", "compare the vectors in this file, captured from the hardware, against the emulator")
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	vec, err := ioutil.ReadFile(*IPL)
+	if err != nil {
+		log.Fatalf("FATAL: Cannot read IPL file %q: %v", *IPL, err)
+	}
+	var stdin []byte
+	if *STDIN != "" {
+		stdin, err = ioutil.ReadFile(*STDIN)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read stdin file %q: %v", *STDIN, err)
+		}
+	}
+
+	vm := &OWL.Vm{Logf: func(format string, args ...any) {}}
+	vm.F = &OWL.BufferPort{Input: stdin}
+	vm.G = OWL.NewArgsExit(vm, flag.Args())
+	rec := OWL.NewVectorRecorder(vm)
+	vm.IPL(vec)
+	vm.Steps(*MAX)
+	log.Printf("owl-vec: %d cycles, stopped=%v at pc=%06x", len(rec.Vectors), vm.Stopped(), vm.PC())
+
+	if *CHECK != "" {
+		r, err := os.Open(*CHECK)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot open captured vectors %q: %v", *CHECK, err)
+		}
+		captured, err := OWL.ReadVectors(r)
+		r.Close()
+		if err != nil {
+			log.Fatalf("FATAL: Cannot read captured vectors %q: %v", *CHECK, err)
+		}
+		if err := OWL.CompareVectors(rec.Vectors, captured); err != nil {
+			log.Fatalf("owl-vec: FAIL: %v", err)
+		}
+		log.Printf("owl-vec: OK: %d cycles match", len(captured))
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if *O != "" {
+		f, err := os.Create(*O)
+		if err != nil {
+			log.Fatalf("FATAL: Cannot create %q: %v", *O, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *FORMAT {
+	case "csv":
+		err = OWL.WriteVectorsCSV(w, rec.Vectors)
+	case "bin":
+		err = OWL.WriteVectorsBinary(w, rec.Vectors)
+	default:
+		log.Fatalf("FATAL: Bad value for -format: %q (want csv or bin)", *FORMAT)
+	}
+	if err != nil {
+		log.Fatalf("FATAL: Cannot write vectors: %v", err)
+	}
+}
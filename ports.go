@@ -0,0 +1,238 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ArgsExit is the conventional port G device.
+// Reading it reads the program arguments, each terminated by a 0 byte,
+// and then 0's when they are exhausted.
+// Writing it exits the program with that status, by stopping the Vm.
+type ArgsExit struct {
+	vm     *Vm
+	args   []byte
+	Exited bool
+	Status byte
+}
+
+func NewArgsExit(vm *Vm, args []string) *ArgsExit {
+	var bb []byte
+	for _, a := range args {
+		bb = append(bb, []byte(a)...) // append bytes from the arg
+		bb = append(bb, 0)            // terminated by a 0 byte
+	}
+	return &ArgsExit{vm: vm, args: bb}
+}
+
+func (ae *ArgsExit) Read() byte {
+	if len(ae.args) == 0 {
+		return 0 // return 0's after args are exhausted
+	}
+	z := ae.args[0]
+	ae.args = ae.args[1:]
+	return z
+}
+
+func (ae *ArgsExit) Write(status byte) {
+	ae.vm.log("ArgsExit: EXIT $%02x", status)
+	ae.Exited = true
+	ae.Status = status
+	ae.vm.Stop()
+}
+
+// BufferPort is a port F device for running programs without a terminal.
+// Reads consume Input, returning 0 when it is exhausted,
+// and writes are appended to Output.
+type BufferPort struct {
+	Input  []byte
+	Output bytes.Buffer
+}
+
+func (bp *BufferPort) Read() byte {
+	if len(bp.Input) == 0 {
+		return 0
+	}
+	z := bp.Input[0]
+	bp.Input = bp.Input[1:]
+	return z
+}
+
+func (bp *BufferPort) Write(x byte) {
+	bp.Output.WriteByte(x)
+}
+
+// Bits read from a TerminalStatus port.
+const (
+	StatusReady = 1 // a byte is ready to be read from port F
+	StatusEOF   = 2 // port F has reached the end of input
+)
+
+// EOFMode says what reading a Terminal does at the end of input.
+type EOFMode int
+
+const (
+	EOFFault EOFMode = iota // stop the Vm, setting the Terminal's Err
+	EOFZero                 // read $00
+	EOFFF                   // read $FF
+)
+
+// ParseEOFMode parses "fault", "zero", or "ff".
+func ParseEOFMode(s string) (EOFMode, error) {
+	switch s {
+	case "fault":
+		return EOFFault, nil
+	case "zero":
+		return EOFZero, nil
+	case "ff":
+		return EOFFF, nil
+	}
+	return 0, fmt.Errorf("bad EOF mode %q (want zero, ff, or fault)", s)
+}
+
+// Terminal is the port F device for a program's standard input and output.
+// In blocking mode, reading it waits for a byte.  In non-blocking mode,
+// reading it reads $00 if no byte is ready; its TerminalStatus says
+// when one is.  If reading or writing fails, the Terminal stops the Vm,
+// setting Err.
+type Terminal struct {
+	vm   *Vm
+	r    io.Reader
+	w    io.Writer
+	eof  EOFMode
+	CRLF bool  // write "\r\n" for "\n", as for a terminal in raw mode
+	Err  error // why the Terminal stopped the Vm
+
+	atEOF   bool
+	keys    chan byte // in non-blocking mode, bytes read by the reader goroutine
+	ready   bool      // in non-blocking mode, pending is valid
+	pending byte
+}
+
+func NewTerminal(vm *Vm, r io.Reader, w io.Writer, eof EOFMode, nonblock bool) *Terminal {
+	term := &Terminal{vm: vm, r: r, w: w, eof: eof}
+	if nonblock {
+		term.keys = make(chan byte, 1024)
+		go term.readKeys()
+	}
+	return term
+}
+
+// readKeys copies input to the keys channel, and closes it at EOF.
+func (term *Terminal) readKeys() {
+	bb := []byte{0}
+	for {
+		n, err := term.r.Read(bb)
+		if n == 1 {
+			term.keys <- bb[0]
+		}
+		if err != nil {
+			close(term.keys)
+			return
+		}
+	}
+}
+
+// poll checks for a byte from the reader goroutine, without waiting.
+func (term *Terminal) poll() {
+	if term.ready || term.atEOF {
+		return
+	}
+	select {
+	case x, ok := <-term.keys:
+		if ok {
+			term.pending, term.ready = x, true
+		} else {
+			term.atEOF = true
+		}
+	default:
+	}
+}
+
+// fail stops the Vm, because of err.
+func (term *Terminal) fail(err error) {
+	term.vm.log("Terminal: STOP: %v", err)
+	if term.Err == nil {
+		term.Err = err
+	}
+	term.vm.Stop()
+}
+
+func (term *Terminal) endOfInput(n int, err error) byte {
+	switch term.eof {
+	case EOFZero:
+		return 0
+	case EOFFF:
+		return 0xFF
+	}
+	term.fail(fmt.Errorf("bad Read (%d; %v)", n, err))
+	return 0
+}
+
+func (term *Terminal) Read() byte {
+	if term.keys != nil {
+		term.poll()
+		if term.ready {
+			term.ready = false
+			return term.pending
+		}
+		if term.atEOF {
+			return term.endOfInput(0, io.EOF)
+		}
+		return 0 // nothing ready
+	}
+
+	if term.atEOF {
+		return term.endOfInput(0, io.EOF)
+	}
+	bb := []byte{0}
+	n, err := term.r.Read(bb)
+	if err != nil || n != 1 {
+		term.atEOF = true
+		return term.endOfInput(n, err)
+	}
+	return bb[0]
+}
+
+func (term *Terminal) Write(x byte) {
+	bb := []byte{x}
+	if term.CRLF && x == '\n' {
+		bb = []byte{'\r', '\n'}
+	}
+	n, err := term.w.Write(bb)
+	if err != nil || n != len(bb) {
+		term.fail(fmt.Errorf("bad Write (%d; %v)", n, err))
+	}
+}
+
+// TerminalStatus is a read-only port (port E) reporting StatusReady
+// and StatusEOF for a Terminal.  A program polls it, in non-blocking
+// mode, to find out if a key was pressed.
+type TerminalStatus struct {
+	Term *Terminal
+}
+
+func (ts *TerminalStatus) Read() byte {
+	term := ts.Term
+	if term.keys == nil {
+		// Blocking mode: a read of port F will wait for a byte.
+		if term.atEOF {
+			return StatusEOF
+		}
+		return StatusReady
+	}
+	term.poll()
+	var status byte
+	if term.ready {
+		status |= StatusReady
+	}
+	if term.atEOF {
+		status |= StatusEOF
+	}
+	return status
+}
+
+func (ts *TerminalStatus) Write(x byte) {
+	ts.Term.fail(fmt.Errorf("cannot write $%02x to the terminal status port", x))
+}
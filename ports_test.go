@@ -0,0 +1,106 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTerminalEOF(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		last byte
+	}{
+		{"zero", 0x00},
+		{"ff", 0xFF},
+		{"fault", 0x00},
+	} {
+		eof, err := ParseEOFMode(tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := &Vm{Logf: quiet}
+		term := NewTerminal(vm, strings.NewReader("a"), io.Discard, eof, false)
+		status := &TerminalStatus{Term: term}
+		if got := status.Read(); got != StatusReady {
+			t.Errorf("%s: status before EOF is $%02x", tc.mode, got)
+		}
+		if got := term.Read(); got != 'a' {
+			t.Errorf("%s: read $%02x", tc.mode, got)
+		}
+		for i := 0; i < 2; i++ {
+			if got := term.Read(); got != tc.last {
+				t.Errorf("%s: read $%02x at EOF, want $%02x", tc.mode, got, tc.last)
+			}
+		}
+		if got := status.Read(); got != StatusEOF {
+			t.Errorf("%s: status at EOF is $%02x", tc.mode, got)
+		}
+		if fault := tc.mode == "fault"; vm.Stopped() != fault || (term.Err != nil) != fault {
+			t.Errorf("%s: stopped %v, Err %v", tc.mode, vm.Stopped(), term.Err)
+		}
+	}
+	if _, err := ParseEOFMode("never"); err == nil {
+		t.Errorf("ParseEOFMode accepted never")
+	}
+}
+
+// waitStatus reads the status port until it is want, or times out.
+func waitStatus(t *testing.T, status Port, want byte) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if status.Read() == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("status never became $%02x", want)
+}
+
+func TestTerminalNonblock(t *testing.T) {
+	r, w := io.Pipe()
+	vm := &Vm{Logf: quiet}
+	term := NewTerminal(vm, r, io.Discard, EOFFF, true)
+	status := &TerminalStatus{Term: term}
+
+	if got := status.Read(); got != 0 {
+		t.Errorf("status with no input is $%02x", got)
+	}
+	if got := term.Read(); got != 0 {
+		t.Errorf("read $%02x with no input", got)
+	}
+	go func() {
+		w.Write([]byte("k"))
+		w.Close()
+	}()
+	waitStatus(t, status, StatusReady)
+	if got := term.Read(); got != 'k' {
+		t.Errorf("read $%02x, want 'k'", got)
+	}
+	waitStatus(t, status, StatusEOF)
+	if got := term.Read(); got != 0xFF {
+		t.Errorf("read $%02x at EOF", got)
+	}
+	if vm.Stopped() {
+		t.Errorf("stopped at EOF, with -eof ff")
+	}
+}
+
+func TestTerminalWrite(t *testing.T) {
+	var out bytes.Buffer
+	vm := &Vm{Logf: quiet}
+	term := NewTerminal(vm, strings.NewReader(""), &out, EOFFault, false)
+	term.CRLF = true
+	for _, x := range []byte("a\nb") {
+		term.Write(x)
+	}
+	if got := out.String(); got != "a\r\nb" {
+		t.Errorf("wrote %q", got)
+	}
+	(&TerminalStatus{Term: term}).Write(1)
+	if !vm.Stopped() || term.Err == nil {
+		t.Errorf("writing the status port did not stop the Vm")
+	}
+}
@@ -0,0 +1,133 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// PortEvent is one byte read from a port, at a given step.
+type PortEvent struct {
+	Step uint64
+	Port string // "E", "F", or "G"
+	Data byte
+}
+
+func (ev PortEvent) String() string {
+	return fmt.Sprintf("%d %s %02x", ev.Step, ev.Port, ev.Data)
+}
+
+// Recorder logs every byte read from the ports it wraps,
+// one PortEvent per line, so the session can be replayed.
+type Recorder struct {
+	vm *Vm
+	w  io.Writer
+}
+
+func NewRecorder(vm *Vm, w io.Writer) *Recorder {
+	return &Recorder{vm: vm, w: w}
+}
+
+// Wrap returns a Port that reads from and writes to port,
+// recording each byte read under the given port name.
+func (rec *Recorder) Wrap(name string, port Port) Port {
+	return &recordingPort{rec: rec, name: name, port: port}
+}
+
+type recordingPort struct {
+	rec  *Recorder
+	name string
+	port Port
+}
+
+func (rp *recordingPort) Read() byte {
+	z := rp.port.Read()
+	ev := PortEvent{Step: rp.rec.vm.StepNum(), Port: rp.name, Data: z}
+	_, err := fmt.Fprintln(rp.rec.w, ev)
+	if err != nil {
+		log.Panicf("Recorder cannot write event %v: %v", ev, err)
+	}
+	return z
+}
+
+func (rp *recordingPort) Write(x byte) {
+	rp.port.Write(x)
+}
+
+// ReadPortEvents parses a log written by a Recorder.
+func ReadPortEvents(r io.Reader) ([]PortEvent, error) {
+	var events []PortEvent
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var ev PortEvent
+		_, err := fmt.Sscanf(line, "%d %s %x", &ev.Step, &ev.Port, &ev.Data)
+		if err != nil {
+			return nil, fmt.Errorf("bad port event on line %d: %q: %v", lineNum, line, err)
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// Replayer feeds recorded bytes back to the Vm.
+// Reads on wrapped ports come from the log, not the real device,
+// and must happen at the same step on the same port as recorded,
+// or the Replayer panics, because the program has diverged.
+type Replayer struct {
+	vm     *Vm
+	events []PortEvent
+	next   int
+}
+
+func NewReplayer(vm *Vm, events []PortEvent) *Replayer {
+	return &Replayer{vm: vm, events: events}
+}
+
+// Wrap returns a Port whose reads are replayed from the log
+// and whose writes go to port.
+func (rep *Replayer) Wrap(name string, port Port) Port {
+	return &replayingPort{rep: rep, name: name, port: port}
+}
+
+// Finish panics if the program did not consume the entire log.
+func (rep *Replayer) Finish() {
+	if rep.next < len(rep.events) {
+		log.Panicf("Replay diverged: program finished at step %d, but %d events remain, next is %v",
+			rep.vm.StepNum(), len(rep.events)-rep.next, rep.events[rep.next])
+	}
+}
+
+type replayingPort struct {
+	rep  *Replayer
+	name string
+	port Port
+}
+
+func (rp *replayingPort) Read() byte {
+	rep := rp.rep
+	step := rep.vm.StepNum()
+	if rep.next >= len(rep.events) {
+		log.Panicf("Replay diverged: step %d reads port %s, but the log is exhausted", step, rp.name)
+	}
+	ev := rep.events[rep.next]
+	if ev.Step != step || ev.Port != rp.name {
+		log.Panicf("Replay diverged: step %d reads port %s, but the log expects %v", step, rp.name, ev)
+	}
+	rep.next++
+	return ev.Data
+}
+
+func (rp *replayingPort) Write(x byte) {
+	if rp.port == nil {
+		log.Panicf("No device to write at port %s", rp.name)
+	}
+	rp.port.Write(x)
+}
@@ -0,0 +1,71 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type bytesPort struct {
+	in  []byte
+	out []byte
+}
+
+func (bp *bytesPort) Read() byte {
+	z := bp.in[0]
+	bp.in = bp.in[1:]
+	return z
+}
+
+func (bp *bytesPort) Write(x byte) {
+	bp.out = append(bp.out, x)
+}
+
+func quiet(format string, args ...any) {}
+
+// echoVm copies two bytes from port F back to port F.
+func echoVm(port Port) *Vm {
+	vm := &Vm{F: port, Logf: quiet}
+	for i, t := range []byte{0x70 /*mv f,a*/, 0x46 /*mv a,f*/, 0x70, 0x46} {
+		vm.Poke(0x100+uint(i), t)
+	}
+	vm.pc = 0x100
+	return vm
+}
+
+func TestRecordReplay(t *testing.T) {
+	var log bytes.Buffer
+	live := &bytesPort{in: []byte("ok")}
+	vm := echoVm(nil)
+	vm.F = NewRecorder(vm, &log).Wrap("F", live)
+	vm.Steps(4)
+	if got, want := log.String(), "0 F 6f\n2 F 6b\n"; got != want {
+		t.Errorf("recorded %q, want %q", got, want)
+	}
+
+	events, err := ReadPortEvents(strings.NewReader(log.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &bytesPort{}
+	vm = echoVm(nil)
+	rep := NewReplayer(vm, events)
+	vm.F = rep.Wrap("F", replayed)
+	vm.Steps(4)
+	rep.Finish()
+	if got := string(replayed.out); got != "ok" {
+		t.Errorf("replayed output %q, want %q", got, "ok")
+	}
+}
+
+func TestReplayDiverges(t *testing.T) {
+	vm := echoVm(nil)
+	rep := NewReplayer(vm, []PortEvent{{Step: 1, Port: "F", Data: 'x'}})
+	vm.F = rep.Wrap("F", &bytesPort{})
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected replay to panic when the step differs")
+		}
+	}()
+	vm.Steps(1)
+}
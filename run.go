@@ -0,0 +1,95 @@
+package ABhL // pronounced "owl"
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Limits bound how long Run may run.  Zero values mean no limit.
+type Limits struct {
+	MaxSteps uint64    // stop after this many steps (counting from this Run)
+	Deadline time.Time // stop at this wall-clock time
+	Hz       uint64    // run no faster than this many clock cycles per second
+}
+
+// StopReason tells why Run returned.
+type StopReason int
+
+const (
+	StopExit      StopReason = iota // a port called Stop, as port G does when the program exits
+	StopUndefined                   // the Vm fetched an undefined instruction (like $00)
+	StopMaxSteps                    // the step budget in Limits ran out
+	StopDeadline                    // the deadline in Limits, or of the context, passed
+	StopCanceled                    // the context was canceled
+	StopLoop                        // OnLoop found a tight loop and said to stop
+)
+
+var stopReasonNames = []string{"program exit", "undefined instruction", "step budget", "deadline", "cancellation", "tight loop"}
+
+func (r StopReason) String() string {
+	if 0 <= int(r) && int(r) < len(stopReasonNames) {
+		return stopReasonNames[r]
+	}
+	return "StopReason(?)"
+}
+
+// How many steps Run executes between checks of the context and clock.
+const runCheckInterval = 1024
+
+// Run executes steps until the program stops, the context is done,
+// or one of the limits is reached, and returns the reason it stopped.
+func (vm *Vm) Run(ctx context.Context, limits Limits) StopReason {
+	start, startCycles := time.Now(), vm.cycles
+	for n := uint64(0); ; n++ {
+		if vm.stopped {
+			return StopExit
+		}
+		if limits.MaxSteps > 0 && n >= limits.MaxSteps {
+			return StopMaxSteps
+		}
+		if limits.Hz > 0 {
+			throttle(ctx, start, vm.cycles-startCycles, limits.Hz)
+		}
+		if n%runCheckInterval == 0 || limits.Hz > 0 {
+			if err := ctx.Err(); err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					return StopDeadline
+				}
+				return StopCanceled
+			}
+			if !limits.Deadline.IsZero() && !time.Now().Before(limits.Deadline) {
+				return StopDeadline
+			}
+		}
+		if !vm.step() {
+			if vm.looped {
+				return StopLoop
+			}
+			if vm.stopped {
+				return StopExit
+			}
+			return StopUndefined
+		}
+	}
+}
+
+// throttle sleeps while the clock at hz is ahead of the wall clock,
+// after the cycles since start, or until the context is done.
+func throttle(ctx context.Context, start time.Time, cycles uint64, hz uint64) {
+	ahead := HardwareTime(cycles, hz) - time.Since(start)
+	if ahead < time.Millisecond {
+		return // not worth a sleep
+	}
+	timer := time.NewTimer(ahead)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// HardwareTime is how long the hardware takes to run the cycles at hz.
+func HardwareTime(cycles uint64, hz uint64) time.Duration {
+	return time.Duration(float64(cycles) / float64(hz) * float64(time.Second))
+}
@@ -0,0 +1,231 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultScriptSteps is how many steps an expect or exit command
+// waits, if the script does not say.
+const DefaultScriptSteps = 1000000
+
+// Script is an expect-style conversation with a program on port F.
+// Each line of a script file is one command:
+//
+//	send "text\n"        ; queue bytes to be read from port F
+//	expect "regexp" 5000 ; run until output on port F matches, within 5000 steps
+//	exit 0 5000          ; run until the program writes status 0 to port G
+//	steps 20000          ; change the default number of steps to wait
+//
+// Strings are quoted as in Go.  Step counts are optional.
+// Text after a semicolon is a comment.
+type Script struct {
+	Filename string
+	Cmds     []*ScriptCmd
+}
+
+type ScriptCmd struct {
+	Line   int
+	Op     string // send, expect, exit, or steps
+	Text   string // for send
+	Re     *regexp.Regexp
+	Status byte
+	Steps  int // zero means the default
+}
+
+func ParseScript(r io.Reader, filename string) (*Script, error) {
+	sc := &Script{Filename: filename}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		words, err := splitScriptLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		cmd, err := parseScriptCmd(words)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNum, err)
+		}
+		cmd.Line = lineNum
+		sc.Cmds = append(sc.Cmds, cmd)
+	}
+	return sc, scanner.Err()
+}
+
+// splitScriptLine splits on spaces, keeping quoted strings (unquoted) as one word.
+func splitScriptLine(line string) (words []string, err error) {
+	s := strings.TrimSpace(line)
+	for s != "" && s[0] != ';' {
+		if s[0] == '"' || s[0] == '`' {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted string: %s", s)
+			}
+			word, _ := strconv.Unquote(q)
+			words = append(words, word)
+			s = s[len(q):]
+		} else {
+			i := strings.IndexAny(s, " \t;")
+			if i < 0 {
+				i = len(s)
+			}
+			words = append(words, s[:i])
+			s = s[i:]
+		}
+		s = strings.TrimLeft(s, " \t")
+	}
+	return words, nil
+}
+
+func parseScriptCmd(words []string) (*ScriptCmd, error) {
+	cmd := &ScriptCmd{Op: strings.ToLower(words[0])}
+	args := words[1:]
+	optSteps := func(i int) error {
+		if len(args) > i+1 {
+			return fmt.Errorf("too many arguments to %s", cmd.Op)
+		}
+		if len(args) == i+1 {
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("bad step count %q", args[i])
+			}
+			cmd.Steps = n
+		}
+		return nil
+	}
+	switch cmd.Op {
+	case "send":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("send takes one string")
+		}
+		cmd.Text = args[0]
+	case "expect":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("expect takes a pattern")
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Re = re
+		if err := optSteps(1); err != nil {
+			return nil, err
+		}
+	case "exit":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("exit takes a status")
+		}
+		status, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad exit status %q", args[0])
+		}
+		cmd.Status = byte(status)
+		if err := optSteps(1); err != nil {
+			return nil, err
+		}
+	case "steps":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("steps takes a count")
+		}
+		if err := optSteps(0); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown script command %q", words[0])
+	}
+	return cmd, nil
+}
+
+// scriptPort is port F during a Script.
+type scriptPort struct {
+	input  []byte
+	output []byte
+	mark   int // output before mark has been matched already
+	echo   io.Writer
+}
+
+func (sp *scriptPort) Read() byte {
+	z := sp.input[0]
+	sp.input = sp.input[1:]
+	return z
+}
+
+func (sp *scriptPort) Write(x byte) {
+	sp.output = append(sp.output, x)
+	if sp.echo != nil {
+		sp.echo.Write([]byte{x})
+	}
+}
+
+// Run runs the script against a Vm that has already had its IPL.
+// It replaces ports F and G of the Vm; args can be read from port G.
+// Output on port F is copied to echo, if it is not nil.
+func (sc *Script) Run(vm *Vm, args []string, echo io.Writer) error {
+	sp := &scriptPort{echo: echo}
+	exit := NewArgsExit(vm, args)
+	vm.F, vm.G = sp, exit
+
+	defaultSteps := DefaultScriptSteps
+	for _, cmd := range sc.Cmds {
+		where := fmt.Sprintf("%s:%d", sc.Filename, cmd.Line)
+		steps := cmd.Steps
+		if steps == 0 {
+			steps = defaultSteps
+		}
+
+		// done tells if the command is satisfied.
+		var done func() bool
+		var what string
+		switch cmd.Op {
+		case "send":
+			sp.input = append(sp.input, []byte(cmd.Text)...)
+			continue
+		case "steps":
+			defaultSteps = cmd.Steps
+			continue
+		case "expect":
+			what = fmt.Sprintf("output matching %q", cmd.Re)
+			done = func() bool {
+				loc := cmd.Re.FindIndex(sp.output[sp.mark:])
+				if loc == nil {
+					return false
+				}
+				sp.mark += loc[1]
+				return true
+			}
+		case "exit":
+			what = fmt.Sprintf("exit status %d", cmd.Status)
+			done = func() bool { return exit.Exited }
+		}
+
+		for i := 0; !done(); i++ {
+			if exit.Exited {
+				return fmt.Errorf("%s: waiting for %s, but program exited with status %d; unmatched output: %q",
+					where, what, exit.Status, sp.output[sp.mark:])
+			}
+			if i == steps {
+				return fmt.Errorf("%s: waiting for %s, but timed out after %d steps; unmatched output: %q",
+					where, what, steps, sp.output[sp.mark:])
+			}
+			if len(sp.input) == 0 && ReadsPortF(vm.Peek(vm.PC())) {
+				return fmt.Errorf("%s: waiting for %s, but program is waiting for input at pc=%06x; unmatched output: %q",
+					where, what, vm.PC(), sp.output[sp.mark:])
+			}
+			if !vm.Steps(1) && !exit.Exited {
+				return fmt.Errorf("%s: waiting for %s, but program stopped at pc=%06x", where, what, vm.PC())
+			}
+		}
+		if cmd.Op == "exit" && exit.Status != cmd.Status {
+			return fmt.Errorf("%s: program exited with status %d, want %d", where, exit.Status, cmd.Status)
+		}
+	}
+	return nil
+}
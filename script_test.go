@@ -0,0 +1,60 @@
+package ABhL // pronounced "owl"
+
+import (
+	"strings"
+	"testing"
+)
+
+// echoUntilZeroVm echoes port F until it reads a 0, then exits with status 0.
+func echoUntilZeroVm() *Vm {
+	vm := &Vm{Logf: quiet}
+	for i, t := range []byte{
+		0x70,       // mv f,a
+		0x46,       // mv a,f
+		0x05, 0x00, // setb 0
+		0x06, 0x01, // seth 1
+		0x07, 0x00, // setl 0
+		0x0C, // bnz
+		0x47, // mv a,g
+	} {
+		vm.Poke(0x100+uint(i), t)
+	}
+	vm.pc = 0x100
+	return vm
+}
+
+func TestScript(t *testing.T) {
+	for _, it := range []struct{ script, wantErr string }{
+		{`
+			send "hello\n"   ; comment
+			expect "hel+o" 100
+			send "bye\x00"
+			expect bye
+			exit 0 50
+		`, ""},
+		{`
+			send "abc"
+			expect "x"
+		`, `test:3: waiting for output matching "x", but program is waiting for input`},
+		{`
+			send "a\x00"
+			exit 1
+		`, "test:3: program exited with status 0, want 1"},
+		{`
+			send "a\x00"
+			expect "b"
+		`, `test:3: waiting for output matching "b", but program exited with status 0`},
+	} {
+		sc, err := ParseScript(strings.NewReader(it.script), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sc.Run(echoUntilZeroVm(), nil, nil)
+		switch {
+		case it.wantErr == "" && err != nil:
+			t.Errorf("unexpected error: %v", err)
+		case it.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), it.wantErr)):
+			t.Errorf("got error %v, want %q", err, it.wantErr)
+		}
+	}
+}
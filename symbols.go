@@ -0,0 +1,108 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Symbol is a label from an assembled module.
+type Symbol struct {
+	Name string
+	Addr uint
+	Kind string // "addr" for code and data, or "equ", "bank", or "row"
+}
+
+// Symbols are the labels of a module, sorted by address.
+type Symbols []Symbol
+
+// ModSymbols lists the labels defined in the module.
+func ModSymbols(mod *Mod) (syms Symbols) {
+	for _, row := range mod.rows {
+		if row.label == "" {
+			continue
+		}
+		lab, ok := mod.labels[row.label]
+		if !ok {
+			continue
+		}
+		kind := "addr"
+		switch row.opcode {
+		case "equ", "bank", "row":
+			kind = row.opcode
+		}
+		syms = append(syms, Symbol{Name: row.label, Addr: lab.addr, Kind: kind})
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	return
+}
+
+// WriteSymbols writes a symbol file: one "addr name kind" line per label.
+func WriteSymbols(mod *Mod, filename string) {
+	var sb strings.Builder
+	for _, sym := range ModSymbols(mod) {
+		fmt.Fprintf(&sb, "%06x %s %s\n", sym.Addr, sym.Name, sym.Kind)
+	}
+	err := ioutil.WriteFile(filename, []byte(sb.String()), 0644)
+	if err != nil {
+		log.Panicf("Error writing symbol file %q: %v", filename, err)
+	}
+}
+
+// ReadSymbols parses a symbol file written by WriteSymbols.
+func ReadSymbols(r io.Reader) (Symbols, error) {
+	var syms Symbols
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var sym Symbol
+		_, err := fmt.Sscanf(line, "%x %s %s", &sym.Addr, &sym.Name, &sym.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("bad symbol on line %d: %q: %v", lineNum, line, err)
+		}
+		syms = append(syms, sym)
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	return syms, scanner.Err()
+}
+
+// ReadSymbolFile reads a symbol file by name.
+func ReadSymbolFile(filename string) (Symbols, error) {
+	r, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ReadSymbols(r)
+}
+
+// Lookup names an address by the nearest "addr" label at or before it,
+// like "loop" or "loop+3".  It returns "" if there is no such label
+// in the same bank.
+func (syms Symbols) Lookup(addr uint) string {
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].Addr > addr })
+	for i--; i >= 0; i-- {
+		sym := syms[i]
+		if sym.Kind != "addr" {
+			continue
+		}
+		if sym.Addr == addr {
+			return sym.Name
+		}
+		if sym.Addr>>16 != addr>>16 {
+			return ""
+		}
+		return fmt.Sprintf("%s+%d", sym.Name, addr-sym.Addr)
+	}
+	return ""
+}
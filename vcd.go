@@ -0,0 +1,178 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// VCDWriter writes every clock cycle of a Vm as a Value Change Dump,
+// for waveform viewers like GTKWave.
+//
+// Each cycle is two half-cycles: clk is high for the first and low
+// for the second.  The buses and strobes are valid for the whole cycle,
+// and the registers (pc, t, a, b, h, l, w) change at its end,
+// on the final edge, when the results are latched.
+type VCDWriter struct {
+	vm      *Vm
+	w       *bufio.Writer
+	half    uint64 // nanoseconds per half-cycle
+	now     uint64 // time of the pending changes
+	signals []vcdSignal
+}
+
+type vcdSignal struct {
+	name    string
+	width   int
+	id      string
+	written uint64
+	pending uint64
+	fresh   bool // not yet written
+}
+
+// Indices of the signals, before the Strobes.
+const (
+	vcdClk = iota
+	vcdExecute
+	vcdIPL
+	vcdAddr
+	vcdData
+	vcdPC
+	vcdT
+	vcdA
+	vcdB
+	vcdH
+	vcdL
+	vcdW
+	vcdStrobes
+)
+
+// NewVCDWriter writes the VCD header to w, and sets vm.OnCycle to dump every cycle,
+// timed as if the clock runs at hz.  Call Close at the end, to flush.
+func NewVCDWriter(vm *Vm, w io.Writer, hz uint64) *VCDWriter {
+	if hz == 0 || hz > 500000000 {
+		panic("NewVCDWriter: hz must be between 1 and 500000000")
+	}
+	v := &VCDWriter{vm: vm, w: bufio.NewWriter(w), half: 500000000 / hz}
+	add := func(name string, width int) {
+		id := ""
+		for n := len(v.signals); ; n /= 94 {
+			id += string(rune('!' + n%94))
+			if n < 94 {
+				break
+			}
+		}
+		v.signals = append(v.signals, vcdSignal{name: name, width: width, id: id, fresh: true})
+	}
+	add("clk", 1)
+	add("execute", 1)
+	add("ipl", 1)
+	add("addr", 24)
+	add("data", 8)
+	add("pc", 24)
+	add("t", 8)
+	add("a", 8)
+	add("b", 8)
+	add("h", 8)
+	add("l", 8)
+	add("w", 24)
+	for _, name := range StrobeNames {
+		add(name, 1)
+	}
+
+	fmt.Fprintf(v.w, "$version ABhL owl-emu $end\n")
+	fmt.Fprintf(v.w, "$timescale 1ns $end\n")
+	fmt.Fprintf(v.w, "$scope module abhl $end\n")
+	for _, sig := range v.signals {
+		if sig.width == 1 {
+			fmt.Fprintf(v.w, "$var wire 1 %s %s $end\n", sig.id, sig.name)
+		} else {
+			fmt.Fprintf(v.w, "$var wire %d %s %s [%d:0] $end\n", sig.width, sig.id, sig.name, sig.width-1)
+		}
+	}
+	fmt.Fprintf(v.w, "$upscope $end\n")
+	fmt.Fprintf(v.w, "$enddefinitions $end\n")
+
+	v.now = vm.Cycles() * 2 * v.half
+	v.setRegisters()
+	vm.OnCycle = v.cycle
+	return v
+}
+
+func (v *VCDWriter) set(i int, val uint64) {
+	v.signals[i].pending = val
+}
+
+func (v *VCDWriter) setRegisters() {
+	vm := v.vm
+	v.set(vcdPC, uint64(vm.pc))
+	v.set(vcdT, uint64(vm.t))
+	v.set(vcdA, uint64(vm.a))
+	v.set(vcdB, uint64(vm.b))
+	v.set(vcdH, uint64(vm.h))
+	v.set(vcdL, uint64(vm.l))
+	v.set(vcdW, uint64(vm.W()))
+}
+
+// advance writes the pending changes, and moves on to a later time.
+func (v *VCDWriter) advance(t uint64) {
+	if t > v.now {
+		v.flush()
+		v.now = t
+	}
+}
+
+func (v *VCDWriter) flush() {
+	stamped := false
+	for i := range v.signals {
+		sig := &v.signals[i]
+		if !sig.fresh && sig.pending == sig.written {
+			continue
+		}
+		if !stamped {
+			fmt.Fprintf(v.w, "#%d\n", v.now)
+			stamped = true
+		}
+		if sig.width == 1 {
+			fmt.Fprintf(v.w, "%d%s\n", sig.pending, sig.id)
+		} else {
+			fmt.Fprintf(v.w, "b%b %s\n", sig.pending, sig.id)
+		}
+		sig.written, sig.fresh = sig.pending, false
+	}
+}
+
+func (v *VCDWriter) cycle(c *Cycle) {
+	start := c.Num * 2 * v.half
+	v.advance(start)
+	v.set(vcdClk, 1)
+	v.set(vcdExecute, vcdBit(c.Execute))
+	v.set(vcdIPL, vcdBit(c.IPL))
+	v.set(vcdAddr, uint64(c.Addr))
+	v.set(vcdData, uint64(c.Data))
+	for i := range StrobeNames {
+		v.set(vcdStrobes+i, uint64(c.Strobes>>i&1))
+	}
+
+	v.advance(start + v.half)
+	v.set(vcdClk, 0)
+
+	v.advance(start + 2*v.half)
+	v.setRegisters()
+	for i := range StrobeNames {
+		v.set(vcdStrobes+i, 0)
+	}
+}
+
+// Close writes the final changes and flushes the output.
+func (v *VCDWriter) Close() error {
+	v.flush()
+	return v.w.Flush()
+}
+
+func vcdBit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
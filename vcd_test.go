@@ -0,0 +1,55 @@
+package ABhL // pronounced "owl"
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVCD(t *testing.T) {
+	var out strings.Builder
+	vm := &Vm{Logf: quiet}
+	v := NewVCDWriter(vm, &out, 1000000) // 500ns half-cycles
+	vm.IPL([]byte{0x04, 0x2A})           // seta $2a, leaving pc=1
+	vm.Poke(1, 0xC3)                     // sta 3
+	vm.Steps(1)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "$var wire 24 $ addr [23:0] $end\n") {
+		t.Errorf("missing addr declaration in:\n%s", got)
+	}
+	_, dump, _ := strings.Cut(got, "$enddefinitions $end\n")
+
+	// The FETCH of sta 3 at $000001, then its EXECUTE at 3000ns,
+	// storing $2a at $000003.
+	want := `#2000
+1!
+0"
+0#
+b1 $
+b11000011 %
+b1 &
+b101010 (
+0-
+11
+#2500
+0!
+#3000
+1!
+1"
+b11 $
+b101010 %
+b10 &
+b11000011 '
+01
+14
+#3500
+0!
+#4000
+04
+`
+	if _, tail, _ := strings.Cut(dump, "#2000\n"); "#2000\n"+tail != want {
+		t.Errorf("got dump:\n%s\nwant to end with:\n%s", dump, want)
+	}
+}
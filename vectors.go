@@ -0,0 +1,286 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DecodeControls returns the control signals the hardware asserts
+// during a FETCH cycle (execute false), or during the EXECUTE cycle
+// of opcode t, as the ISA decodes it.  A BNZ loads the PC only if
+// A (the value in register A) is not zero.  Undefined opcodes assert nothing.
+func DecodeControls(t byte, execute bool, a byte) Strobes {
+	if !execute {
+		return LatchT
+	}
+	op := Opcodes[t]
+	if op == nil {
+		return 0
+	}
+	switch op.Mnemonic {
+	case "mv":
+		return readStrobes[op.Field(t, 'f')] | writeStrobes[op.Field(t, 't')]
+	case "ld":
+		return ReadM | writeStrobes[op.Field(t, 'r')]
+	case "st":
+		return WriteM
+	case "set":
+		return writeStrobes[op.Field(t, 'r')]
+	case "inca", "deca":
+		return LatchA
+	case "incw", "decw":
+		return LatchB | LatchH | LatchL
+	case "bnz":
+		if a != 0 {
+			return LoadPC
+		}
+	}
+	return 0
+}
+
+// String names the strobes, like "read_f|latch_a", or "-" for none.
+func (s Strobes) String() string {
+	var names []string
+	for i, name := range StrobeNames {
+		if s&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, "|")
+}
+
+// ParseStrobes parses the result of Strobes.String.
+func ParseStrobes(str string) (Strobes, error) {
+	if str == "-" {
+		return 0, nil
+	}
+	var s Strobes
+	for _, name := range strings.Split(str, "|") {
+		found := false
+		for i, n := range StrobeNames {
+			if n == name {
+				s |= 1 << i
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown strobe %q", name)
+		}
+	}
+	return s, nil
+}
+
+// VectorRecorder collects a test vector for every cycle of a Vm:
+// what the hardware should show on the address bus, the data bus,
+// and the control signals (as DecodeControls decodes them).
+type VectorRecorder struct {
+	Vectors []Cycle
+}
+
+// NewVectorRecorder sets vm.OnCycle to record vectors.
+func NewVectorRecorder(vm *Vm) *VectorRecorder {
+	vr := &VectorRecorder{}
+	vm.OnCycle = func(c *Cycle) {
+		v := *c
+		v.Strobes = DecodeControls(vm.T(), c.Execute, vm.A())
+		vr.Vectors = append(vr.Vectors, v)
+	}
+	return vr
+}
+
+const vectorCSVHeader = "cycle,phase,ipl,addr,data,controls"
+
+// WriteVectorsCSV writes one line per cycle, after a header line, like
+//
+//	cycle,phase,ipl,addr,data,controls
+//	8,F,0,000100,46,latch_t
+//	9,E,0,000100,41,read_f|latch_a
+func WriteVectorsCSV(w io.Writer, vectors []Cycle) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, vectorCSVHeader)
+	for _, v := range vectors {
+		phase := "F"
+		if v.Execute {
+			phase = "E"
+		}
+		fmt.Fprintf(bw, "%d,%s,%d,%06x,%02x,%s\n", v.Num, phase, vcdBit(v.IPL), v.Addr, v.Data, v.Strobes)
+	}
+	return bw.Flush()
+}
+
+// vectorMagic starts a binary vector file.  After it comes the number
+// of the first cycle (8 bytes, little-endian), and then 8 bytes per cycle:
+// flags (1 for EXECUTE, 2 for IPL), the address bus (3 bytes, big-endian),
+// the data bus, the control signals (2 bytes, little-endian), and a 0.
+const vectorMagic = "OWLVEC1\n"
+
+// WriteVectorsBinary writes the compact binary form, for small devices.
+// The cycles must be consecutive.
+func WriteVectorsBinary(w io.Writer, vectors []Cycle) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(vectorMagic)
+	var first uint64
+	if len(vectors) > 0 {
+		first = vectors[0].Num
+	}
+	binary.Write(bw, binary.LittleEndian, first)
+	for i, v := range vectors {
+		if v.Num != first+uint64(i) {
+			return fmt.Errorf("cycle %d is not consecutive, after cycle %d", v.Num, first+uint64(i)-1)
+		}
+		flags := byte(vcdBit(v.Execute) | vcdBit(v.IPL)<<1)
+		bw.Write([]byte{flags, byte(v.Addr >> 16), byte(v.Addr >> 8), byte(v.Addr), v.Data, byte(v.Strobes), byte(v.Strobes >> 8), 0})
+	}
+	return bw.Flush()
+}
+
+// ReadVectors reads vectors in either the CSV or the binary form.
+func ReadVectors(r io.Reader) ([]Cycle, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(vectorMagic))
+	if string(head) == vectorMagic {
+		return readVectorsBinary(br)
+	}
+	return readVectorsCSV(br)
+}
+
+func readVectorsBinary(r io.Reader) ([]Cycle, error) {
+	all, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	all = all[len(vectorMagic):]
+	if len(all) < 8 || len(all)%8 != 0 {
+		return nil, fmt.Errorf("binary vector file has bad length")
+	}
+	first := binary.LittleEndian.Uint64(all)
+	var vectors []Cycle
+	for i := 8; i < len(all); i += 8 {
+		rec := all[i : i+8]
+		vectors = append(vectors, Cycle{
+			Num:     first + uint64(len(vectors)),
+			Execute: rec[0]&1 != 0,
+			IPL:     rec[0]&2 != 0,
+			Addr:    uint(rec[1])<<16 | uint(rec[2])<<8 | uint(rec[3]),
+			Data:    rec[4],
+			Strobes: Strobes(rec[5]) | Strobes(rec[6])<<8,
+		})
+	}
+	return vectors, nil
+}
+
+func readVectorsCSV(r io.Reader) ([]Cycle, error) {
+	var vectors []Cycle
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == vectorCSVHeader {
+			continue
+		}
+		v, err := parseVectorCSV(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad vector on line %d: %q: %v", lineNum, line, err)
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, scanner.Err()
+}
+
+func parseVectorCSV(line string) (v Cycle, err error) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 6 {
+		return v, fmt.Errorf("want 6 fields, got %d", len(fields))
+	}
+	num, err1 := strconv.ParseUint(fields[0], 10, 64)
+	addr, err2 := strconv.ParseUint(fields[3], 16, 24)
+	data, err3 := strconv.ParseUint(fields[4], 16, 8)
+	strobes, err4 := ParseStrobes(fields[5])
+	for _, err := range []error{err1, err2, err3, err4} {
+		if err != nil {
+			return v, err
+		}
+	}
+	switch fields[1] {
+	case "F":
+	case "E":
+		v.Execute = true
+	default:
+		return v, fmt.Errorf("phase must be F or E")
+	}
+	switch fields[2] {
+	case "0":
+	case "1":
+		v.IPL = true
+	default:
+		return v, fmt.Errorf("ipl must be 0 or 1")
+	}
+	v.Num, v.Addr, v.Data, v.Strobes = num, uint(addr), byte(data), strobes
+	return v, nil
+}
+
+// CompareVectors compares vectors captured from the hardware
+// against the expected vectors, and returns an error describing
+// the first mismatch, or nil if they match.
+func CompareVectors(want, got []Cycle) error {
+	for i := range want {
+		w := want[i]
+		if i >= len(got) {
+			return fmt.Errorf("capture ends after %d cycles, but cycle %d (%s) was expected", len(got), w.Num, describeVector(w))
+		}
+		g := got[i]
+		var diffs bytes.Buffer
+		note := func(format string, args ...any) {
+			if diffs.Len() > 0 {
+				diffs.WriteString("; ")
+			}
+			fmt.Fprintf(&diffs, format, args...)
+		}
+		if g.Num != w.Num {
+			note("cycle number is %d", g.Num)
+		}
+		if g.Execute != w.Execute || g.IPL != w.IPL {
+			note("phase is %s", describePhase(g))
+		}
+		if g.Addr != w.Addr {
+			note("address bus is $%06x, want $%06x", g.Addr, w.Addr)
+		}
+		if g.Data != w.Data {
+			note("data bus is $%02x, want $%02x", g.Data, w.Data)
+		}
+		if g.Strobes != w.Strobes {
+			note("controls are %v, want %v", g.Strobes, w.Strobes)
+		}
+		if diffs.Len() > 0 {
+			return fmt.Errorf("mismatch at vector %d, cycle %d (%s): %s", i, w.Num, describeVector(w), diffs.String())
+		}
+	}
+	if len(got) > len(want) {
+		return fmt.Errorf("capture has %d cycles, but only %d were expected", len(got), len(want))
+	}
+	return nil
+}
+
+func describePhase(v Cycle) string {
+	phase := "FETCH"
+	if v.Execute {
+		phase = "EXECUTE"
+	}
+	if v.IPL {
+		phase = "IPL " + phase
+	}
+	return phase
+}
+
+func describeVector(v Cycle) string {
+	return fmt.Sprintf("%s addr=$%06x data=$%02x controls=%v", describePhase(v), v.Addr, v.Data, v.Strobes)
+}
@@ -0,0 +1,59 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVectors(t *testing.T) {
+	vm := &Vm{Logf: quiet}
+	vm.G = NewArgsExit(vm, []string{"\xF0"})
+	vr := NewVectorRecorder(vm)
+	var emulated []Strobes
+	record := vm.OnCycle
+	vm.OnCycle = func(c *Cycle) {
+		emulated = append(emulated, c.Strobes)
+		record(c)
+	}
+	vm.IPL(fillIPL())
+	vm.Steps(10000)
+	if !vm.Stopped() {
+		t.Fatalf("program did not exit")
+	}
+
+	// What the emulator does must match what the decoder says.
+	for i, v := range vr.Vectors {
+		if v.Strobes != emulated[i] {
+			t.Fatalf("cycle %d: decoded %v, emulated %v", v.Num, v.Strobes, emulated[i])
+		}
+	}
+
+	var csv, bin bytes.Buffer
+	if err := WriteVectorsCSV(&csv, vr.Vectors); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteVectorsBinary(&bin, vr.Vectors); err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []*bytes.Buffer{&csv, &bin} {
+		got, err := ReadVectors(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CompareVectors(vr.Vectors, got); err != nil {
+			t.Errorf("round trip: %v", err)
+		}
+	}
+
+	bad := append([]Cycle(nil), vr.Vectors...)
+	bad[100].Data ^= 0x10
+	err := CompareVectors(vr.Vectors, bad)
+	if want := fmt.Sprintf("mismatch at vector 100, cycle %d", vr.Vectors[100].Num); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got %v, want %q...", err, want)
+	}
+	if err := CompareVectors(vr.Vectors, bad[:50]); err == nil || !strings.HasPrefix(err.Error(), "capture ends after 50 cycles") {
+		t.Errorf("short capture: got %v", err)
+	}
+}
@@ -2,8 +2,16 @@ package ABhL // pronounced "owl"
 
 import "log"
 
-const RamSize = 1024 * 1024 * 1024 // One megabyte
+// RamSize is how much RAM the 24-bit W can address: 16 megabytes.
+// (A standard ABhL computer has only one megabyte.)
+const RamSize = 1 << 24
 
+// PageSize is the unit in which a Vm allocates RAM: one bank.
+const PageSize = 1 << 16
+
+// Log is the logger used by a Vm whose Logf is nil.
+// If Vms run in several goroutines, it must be safe for concurrent use,
+// as log.Printf is, and it must not be changed while they run.
 var Log = log.Printf
 
 type Port interface {
@@ -11,20 +19,103 @@ type Port interface {
 	Write(byte)
 }
 
+// Vm emulates one ABhL machine.
+//
+// Separate Vms share no mutable state, so each may run in its own goroutine
+// (as long as they do not share Ports, and Logf is safe to call).
+// A single Vm is not safe for concurrent use.
 type Vm struct {
 	a, b, h, l, m, t, imm byte
 	pc                    uint
-	E, F, G               Port
-	ram                   [RamSize]byte
+	steps                 uint64 // number of steps executed, not counting IPL
+	cycles                uint64 // number of clock cycles, counting IPL
+	stopped               bool   // set by Stop
+	events                uint64 // count of memory writes and port reads and writes
+
+	// OnLoop, if not nil, enables detection of tight loops:
+	// a taken BNZ reached again with the same A, B, H, and L,
+	// with no memory writes or port I/O in between, so it can never exit.
+	// OnLoop is called with the address of the BNZ;
+	// if it returns true, the Vm stops, and Run returns StopLoop.
+	OnLoop  func(addr uint) bool
+	loops   map[uint]*loopEntry // at each taken BNZ, the state last time
+	looped  bool                // the last step was stopped by OnLoop
+	E, F, G Port
+
+	// OnCycle, if not nil, is called after every clock cycle,
+	// FETCH and EXECUTE, including during IPL.
+	OnCycle func(c *Cycle)
+	cycle   Cycle // the EXECUTE cycle in progress
+
+	// Logf logs what the Vm does at every step.  If nil, Log is used.
+	Logf func(format string, args ...any)
+
+	// ram is allocated a page at a time, when first written.
+	// Pages never written read as zeros.
+	ram [RamSize / PageSize]*[PageSize]byte
+}
+
+func (vm *Vm) log(format string, args ...any) {
+	if vm.Logf != nil {
+		vm.Logf(format, args...)
+	} else {
+		Log(format, args...)
+	}
+}
+
+func (vm *Vm) read(addr uint) byte {
+	page := vm.ram[(addr&(RamSize-1))/PageSize]
+	if page == nil {
+		return 0
+	}
+	return page[addr%PageSize]
+}
+
+func (vm *Vm) write(addr uint, val byte) {
+	p := (addr & (RamSize - 1)) / PageSize
+	if vm.ram[p] == nil {
+		vm.ram[p] = new([PageSize]byte)
+	}
+	vm.ram[p][addr%PageSize] = val
+}
+
+// quick returns a copy of the quick registers, for logging.
+func (vm *Vm) quick() []byte {
+	if vm.ram[0] == nil {
+		return make([]byte, 16)
+	}
+	return append([]byte(nil), vm.ram[0][:16]...)
+}
+
+// Pages returns the numbers of the pages (banks) of RAM that have been written.
+func (vm *Vm) Pages() (pages []uint) {
+	for i, page := range vm.ram {
+		if page != nil {
+			pages = append(pages, uint(i))
+		}
+	}
+	return
 }
 
 var RegNames = []string{"A", "B", "H", "L", "Mem", "PortE", "PortF", "PortG"}
 
+func (vm *Vm) A() byte { return vm.a }
+func (vm *Vm) B() byte { return vm.b }
+func (vm *Vm) H() byte { return vm.h }
+func (vm *Vm) L() byte { return vm.l }
+
+// T is the opcode latch: the instruction fetched by the last step.
+func (vm *Vm) T() byte { return vm.t }
+
 func (vm *Vm) W() uint {
 	return BhlJoin(vm.b, vm.h, vm.l)
 }
 
 func (vm *Vm) GetReg(reg byte) byte {
+	if reg >= 5 {
+		vm.events++ // reading a port
+	}
+	vm.cycle.Strobes |= readStrobes[7&reg]
 	switch reg {
 	case 0:
 		return vm.a
@@ -35,7 +126,7 @@ func (vm *Vm) GetReg(reg byte) byte {
 	case 3:
 		return vm.l
 	case 4:
-		return vm.m // Note during IPL, this is not vm.ram[vm.W()]
+		return vm.m // Note during IPL, this is not the RAM at W
 	case 5:
 		if vm.E == nil {
 			panic("No device to read at port E")
@@ -57,6 +148,11 @@ func (vm *Vm) GetReg(reg byte) byte {
 }
 
 func (vm *Vm) PutReg(reg byte, val byte) {
+	if reg >= 4 {
+		vm.events++ // writing memory or a port
+	}
+	vm.cycle.Strobes |= writeStrobes[7&reg]
+	vm.cycle.Data = val
 	switch reg {
 	case 0:
 		vm.a = val
@@ -67,7 +163,7 @@ func (vm *Vm) PutReg(reg byte, val byte) {
 	case 3:
 		vm.l = val
 	case 4:
-		vm.ram[vm.W()] = val
+		vm.write(vm.W(), val)
 	case 5:
 		if vm.E == nil {
 			panic("No device to write at port E")
@@ -88,22 +184,122 @@ func (vm *Vm) PutReg(reg byte, val byte) {
 	}
 }
 
+// StepNum is the number of the step currently being executed,
+// counting from 0 at the first step after IPL.
+func (vm *Vm) StepNum() uint64 {
+	return vm.steps
+}
+
+// CyclesPerStep is the number of clock cycles in a step:
+// one FETCH cycle and one EXECUTE cycle.  IPL also takes
+// two cycles for each pair of bytes.
+const CyclesPerStep = 2
+
+// Cycles is the number of clock cycles so far, including IPL.
+func (vm *Vm) Cycles() uint64 {
+	return vm.cycles
+}
+
+// PC is the address of the next instruction to fetch.
+func (vm *Vm) PC() uint {
+	return vm.pc
+}
+
+// Peek returns the byte in RAM at addr, without side effects.
+func (vm *Vm) Peek(addr uint) byte {
+	return vm.read(addr)
+}
+
+// Poke stores a byte in RAM at addr.
+func (vm *Vm) Poke(addr uint, val byte) {
+	vm.write(addr, val)
+}
+
+// Stop makes Steps return false after the current step.
+// Ports call it when the program has asked to exit.
+func (vm *Vm) Stop() {
+	vm.stopped = true
+}
+
+func (vm *Vm) Stopped() bool {
+	return vm.stopped
+}
+
 func (vm *Vm) Steps(n int) bool {
 	for i := 0; i < n; i++ {
-		vm.t = vm.ram[vm.pc]
-		vm.m = vm.ram[vm.W()]
-		vm.pc++
-		vm.imm = vm.ram[vm.pc]
-		Log("Step %x. pc=%06x t=%02x m=%02x imm=%02x", i, vm.pc-1, vm.t, vm.m, vm.imm)
-		ok := vm.Execute()
-		Log(".....%x: pc=%06x a=%02x w=%06x mem=% 3x ...", i, vm.pc, vm.a, vm.W(), vm.ram[:16])
-		if !ok {
+		if vm.stopped {
+			return false
+		}
+		if !vm.step() {
 			return false // stopped short
 		}
 	}
 	return true // all steps succeeded
 }
 
+// step fetches and executes one instruction.
+// It returns false if the instruction is undefined, or the Vm was stopped.
+func (vm *Vm) step() bool {
+	i, at := vm.steps, vm.pc
+	vm.looped = false
+	vm.t = vm.read(vm.pc)
+	vm.m = vm.read(vm.W())
+	vm.pc++
+	vm.imm = vm.read(vm.pc)
+	vm.log("Step %x. pc=%06x t=%02x m=%02x imm=%02x", i, vm.pc-1, vm.t, vm.m, vm.imm)
+	if vm.OnCycle != nil {
+		vm.OnCycle(&Cycle{Num: vm.cycles, Addr: at, Data: vm.t, Strobes: LatchT})
+	}
+	vm.cycle = Cycle{Num: vm.cycles + 1, Execute: true, Addr: vm.W(), Data: vm.m}
+	ok := vm.Execute()
+	vm.log(".....%x: pc=%06x a=%02x w=%06x mem=% 3x ...", i, vm.pc, vm.a, vm.W(), vm.quick())
+	vm.steps++
+	vm.cycles += uint64(CyclesOf(vm.t))
+	if vm.OnCycle != nil {
+		vm.OnCycle(&vm.cycle)
+	}
+	if vm.OnLoop != nil && IsBranch(vm.t) && vm.a != 0 && vm.checkLoop(at) {
+		return false
+	}
+	return ok && !vm.stopped
+}
+
+type loopState struct {
+	a, b, h, l byte
+	events     uint64
+}
+
+type loopEntry struct {
+	state    loopState
+	reported bool
+}
+
+// checkLoop is called after the BNZ at addr is taken.
+// It returns true if a loop is found, and OnLoop says to stop.
+// Each loop is reported only once.
+func (vm *Vm) checkLoop(addr uint) bool {
+	if vm.loops == nil {
+		vm.loops = make(map[uint]*loopEntry)
+	}
+	now := loopState{vm.a, vm.b, vm.h, vm.l, vm.events}
+	entry, ok := vm.loops[addr]
+	if !ok {
+		vm.loops[addr] = &loopEntry{state: now}
+		return false
+	}
+	if entry.state != now || entry.reported {
+		entry.state = now
+		return false
+	}
+	vm.log("    LOOP detected at BNZ %06x", addr)
+	entry.reported = true
+	if vm.OnLoop(addr) {
+		vm.looped = true
+		return true
+	}
+	return false
+}
+
 // IPL for Initial Program Load.
 // Pairs of bytes from vec are injected into t and m at each step.
 func (vm *Vm) IPL(vec []byte) {
@@ -113,74 +309,32 @@ func (vm *Vm) IPL(vec []byte) {
 		vm.t = vec[0]
 		vm.m = vec[1]
 		vm.imm = vec[1]
-		Log("IPL %x. pc=%06x t=%02x m=imm=%02x", i, vm.pc, vm.t, vm.m)
+		vm.log("IPL %x. pc=%06x t=%02x m=imm=%02x", i, vm.pc, vm.t, vm.m)
 		vec = vec[2:] // In IPL mode, always consume a fetch and an execute value.
+		if vm.OnCycle != nil {
+			vm.OnCycle(&Cycle{Num: vm.cycles, IPL: true, Addr: vm.pc, Data: vm.t, Strobes: LatchT})
+		}
+		vm.cycle = Cycle{Num: vm.cycles + 1, Execute: true, IPL: true, Addr: vm.W(), Data: vm.m}
 		ok := vm.Execute()
-		Log(".....%x: pc=%06x a=%02x w=%06x mem=% 3x ...", i, vm.pc, vm.a, vm.W(), vm.ram[:16])
+		vm.log(".....%x: pc=%06x a=%02x w=%06x mem=% 3x ...", i, vm.pc, vm.a, vm.W(), vm.quick())
+		vm.cycles += uint64(CyclesOf(vm.t))
+		if vm.OnCycle != nil {
+			vm.OnCycle(&vm.cycle)
+		}
 		if !ok {
 			panic("IPL stopped short")
 		}
 	}
 }
 
+// Execute executes the opcode in T, as decoded by the ISA.
+// It returns false if the opcode is undefined (or is STOP).
 func (vm *Vm) Execute() bool {
-	t := vm.t
-	switch t >> 6 {
-	case 0:
-		r := 3 & t
-		switch t & 0x3C {
-		case 0x00: // undefined
-			return false
-		case 0x04: // SETr
-			Log("    SET%s immediate $%x", RegNames[r], vm.imm)
-			vm.PutReg(t&3, vm.imm)
-			vm.pc++
-		case 0x08: // Inc/Dec
-			switch 3 & t {
-			case 0:
-				vm.a++
-				Log("    INCA becomes %x", vm.a)
-			case 1:
-				vm.a--
-				Log("    DECA becomes %x", vm.a)
-			case 2:
-				vm.b, vm.h, vm.l = BhlSplit(vm.W() + 1)
-				Log("    INCW becomes %x", vm.W())
-			case 3:
-				vm.b, vm.h, vm.l = BhlSplit(vm.W() - 1)
-				Log("    DECW becomes %x", vm.W())
-			}
-		case 0x0C: // BNZ
-			if r != 0 {
-				return false // undefined instructions
-			}
-			if vm.a == 0 {
-				Log("    BNZ (not taken)")
-			} else {
-				Log("    BNZ ... branching to %x", vm.W())
-				vm.pc = vm.W()
-			}
-		default:
-			return false // undefined instructions
-		}
-	case 1: // MV
-		from, to := 7&(t>>3), 7&t
-		val := vm.GetReg(from)
-		Log("    MV value $%x from %s to %s", val, RegNames[from], RegNames[to])
-		vm.PutReg(to, val)
-	case 2: // LDr
-		to, addr := 3&(t>>4), 15&t
-		val := vm.ram[addr]
-		Log("    LD%s value $%x from addr $%x", RegNames[to], val, addr)
-		vm.PutReg(to, val)
-	case 3: // STr
-		from, addr := 3&(t>>4), 15&t
-		val := vm.GetReg(from)
-		Log("    ST%s value $%x to addr $%x", RegNames[from], val, addr)
-		vm.ram[addr] = val
-	default:
-		panic("bad t")
+	op := Opcodes[vm.t]
+	if op == nil || op.exec == nil {
+		return false
 	}
+	op.exec(vm, op, vm.t)
 	return true
 }
 
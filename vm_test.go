@@ -0,0 +1,182 @@
+package ABhL // pronounced "owl"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fillProgram reads a seed from port G, stores seed..255 at $050000 onward, and exits 0.
+const fillProgram = `
+	org $100
+start	seta 5
+	sta 0
+	seta 0
+	sta 1
+	sta 2
+	mv g,a
+loop	ldb 0
+	ldh 1
+	ldl 2
+	mv a,m
+	incw
+	stb 0
+	sth 1
+	stl 2
+	inca
+	setb b(loop)
+	seth h(loop)
+	setl l(loop)
+	bnz
+	mv a,g
+`
+
+func fillIPL() []byte {
+	lines := strings.Split(fillProgram, "\n")
+	wheres := make([]string, len(lines))
+	for i := range lines {
+		wheres[i] = fmt.Sprintf("fill:%d", i+1)
+	}
+	return CreateIPL(AssembleLines(lines, wheres, nil))
+}
+
+// TestConcurrentVms runs many Vms in parallel; run it with -race.
+func TestConcurrentVms(t *testing.T) {
+	ipl := fillIPL()
+
+	const n = 32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(seed byte) {
+			defer wg.Done()
+			var logged bytes.Buffer
+			vm := &Vm{}
+			vm.Logf = func(format string, args ...any) {
+				fmt.Fprintf(&logged, format+"\n", args...)
+			}
+			exit := NewArgsExit(vm, []string{string([]byte{seed})})
+			vm.G = exit
+			vm.IPL(ipl)
+			vm.Steps(100000)
+
+			if !exit.Exited || exit.Status != 0 {
+				t.Errorf("seed %d: exited=%v status=%d", seed, exit.Exited, exit.Status)
+			}
+			for j := uint(0); j < 256-uint(seed); j++ {
+				if got, want := vm.Peek(0x050000+j), seed+byte(j); got != want {
+					t.Errorf("seed %d: mem[%x] = %x, want %x", seed, 0x050000+j, got, want)
+					break
+				}
+			}
+			if got := fmt.Sprint(vm.Pages()); got != "[0 5]" {
+				t.Errorf("seed %d: allocated pages %s, want [0 5]", seed, got)
+			}
+			if n := strings.Count(logged.String(), "ArgsExit: EXIT"); n != 1 {
+				t.Errorf("seed %d: log shows %d exits, want 1", seed, n)
+			}
+		}(byte(10 + 7*i))
+	}
+	wg.Wait()
+}
+
+func TestRunStopReasons(t *testing.T) {
+	loop := func() *Vm {
+		// A jump to itself, forever.
+		vm := &Vm{Logf: quiet}
+		vm.IPL([]byte{0x04, 1, 0x05, 0, 0x06, 0, 0x07, 0x40})
+		vm.Poke(0x40, 0x0C) // bnz
+		vm.pc = 0x40
+		return vm
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if got := loop().Run(context.Background(), Limits{MaxSteps: 100}); got != StopMaxSteps {
+		t.Errorf("with MaxSteps, got %v", got)
+	}
+	if got := loop().Run(context.Background(), Limits{Deadline: time.Now().Add(time.Millisecond)}); got != StopDeadline {
+		t.Errorf("with Deadline, got %v", got)
+	}
+	if got := loop().Run(canceled, Limits{}); got != StopCanceled {
+		t.Errorf("with canceled context, got %v", got)
+	}
+
+	vm := &Vm{Logf: quiet}
+	if got := vm.Run(context.Background(), Limits{}); got != StopUndefined {
+		t.Errorf("at $00, got %v", got)
+	}
+
+	vm = &Vm{Logf: quiet}
+	exit := NewArgsExit(vm, nil)
+	vm.G = exit
+	vm.Poke(0, 0x47) // mv a,g
+	if got := vm.Run(context.Background(), Limits{}); got != StopExit || !exit.Exited {
+		t.Errorf("at mv a,g, got %v", got)
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	vm := &Vm{Logf: quiet}
+	var found []uint
+	vm.OnLoop = func(addr uint) bool {
+		found = append(found, addr)
+		return true
+	}
+	// Count A down from 3 at $40, which is not a tight loop,
+	// then jump to itself at $50, which is.
+	vm.IPL([]byte{0x04, 3, 0x05, 0, 0x06, 0, 0x07, 0x40})
+	for i, t := range []byte{0x09 /*deca*/, 0x0C /*bnz*/, 0x04, 1 /*seta 1*/, 0x07, 0x50 /*setl $50*/, 0x0C /*bnz*/} {
+		vm.Poke(0x40+uint(i), t)
+	}
+	vm.Poke(0x50, 0x0C) // bnz
+	vm.pc = 0x40
+
+	if got := vm.Run(context.Background(), Limits{MaxSteps: 1000}); got != StopLoop {
+		t.Errorf("got %v, want %v", got, StopLoop)
+	}
+	if fmt.Sprint(found) != "[80]" {
+		t.Errorf("found loops at %v, want [80]", found)
+	}
+}
+
+// TestLoopThenUndefined checks that a loop stop in Steps
+// is not reported again by a later Run.
+func TestLoopThenUndefined(t *testing.T) {
+	vm := &Vm{Logf: quiet}
+	vm.OnLoop = func(addr uint) bool { return true }
+	vm.IPL([]byte{0x04, 1, 0x05, 0, 0x06, 0, 0x07, 0x50})
+	vm.Poke(0x50, 0x0C) // bnz to itself
+	vm.pc = 0x50
+	if vm.Steps(10) {
+		t.Fatalf("Steps did not stop at the loop")
+	}
+	vm.Poke(0x50, 0x01) // undefined
+	if got := vm.Run(context.Background(), Limits{MaxSteps: 10}); got != StopUndefined {
+		t.Errorf("got %v, want %v", got, StopUndefined)
+	}
+}
+
+func TestCyclesAndHz(t *testing.T) {
+	vm := &Vm{Logf: quiet}
+	vm.IPL([]byte{0x04, 1, 0x05, 0, 0x06, 0, 0x07, 0x40})
+	vm.Poke(0x40, 0x0C) // bnz
+	vm.pc = 0x40
+	if got := vm.Cycles(); got != 8 {
+		t.Errorf("after IPL, got %d cycles, want 8", got)
+	}
+
+	// 500 steps at 100 kHz is 1000 cycles, or 10ms.
+	began := time.Now()
+	vm.Run(context.Background(), Limits{MaxSteps: 500, Hz: 100000})
+	if elapsed := time.Since(began); elapsed < 9*time.Millisecond {
+		t.Errorf("throttled run took %v, want at least 10ms", elapsed)
+	}
+	if got := vm.Cycles(); got != 1008 {
+		t.Errorf("got %d cycles, want 1008", got)
+	}
+}